@@ -20,6 +20,7 @@ import (
 
 var version = flag.Bool("version", false, "Print version")
 var tileRoot = flag.String("tileRoot", "./tiles", "Root directory for tiles")
+var storeRoot = flag.String("storeRoot", "", "Serve the world.bin files written by cddamapgen --filestore in the world folders of this directory instead of a database")
 
 func init() {
 	f := &log.TextFormatter{
@@ -39,25 +40,19 @@ func main() {
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
-	connectionString := os.Getenv("CDDAMAP_CONNECTION_STRING")
-	var db server.DB
-	if err := db.Open(connectionString); err != nil {
-		log.Fatal(err)
-	}
-
-	migrationsPath := os.Getenv("CDDAMAP_MIGRATIONS_PATH")
-	g, err := migrate.New(migrationsPath, connectionString)
-	if err != nil {
-		time.Sleep(30 * time.Second)
-		log.Fatal("Couldn't create migrator: ", err)
-	}
-
-	if err = g.Up(); err != nil {
-		if err != migrate.ErrNoChange {
+	var store server.Store
+	if *storeRoot != "" {
+		fs, err := server.OpenFileStore(*storeRoot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = fs
+	} else {
+		db, err := openDB()
+		if err != nil {
 			log.Fatal(err)
-		} else {
-			log.Info("Migrations up to date")
 		}
+		store = db
 	}
 
 	absTileRoot, err := filepath.Abs(*tileRoot)
@@ -65,7 +60,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	s := server.NewHTTPServer(&db, absTileRoot)
+	s := server.NewHTTPServer(store, absTileRoot)
 	router, err := server.CreateRouter(s)
 	if err != nil {
 		log.Fatal(err)
@@ -79,3 +74,28 @@ func main() {
 
 	<-interrupt
 }
+
+// openDB connects to the database and brings its schema up to date.
+func openDB() (*server.DB, error) {
+	connectionString := os.Getenv("CDDAMAP_CONNECTION_STRING")
+	var db server.DB
+	if err := db.Open(connectionString); err != nil {
+		return nil, err
+	}
+
+	migrationsPath := os.Getenv("CDDAMAP_MIGRATIONS_PATH")
+	g, err := migrate.New(migrationsPath, connectionString)
+	if err != nil {
+		time.Sleep(30 * time.Second)
+		return nil, fmt.Errorf("couldn't create migrator: %v", err)
+	}
+
+	if err = g.Up(); err != nil {
+		if err != migrate.ErrNoChange {
+			return nil, err
+		}
+		log.Info("Migrations up to date")
+	}
+
+	return &db, nil
+}
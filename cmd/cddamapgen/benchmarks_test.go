@@ -16,78 +16,78 @@ var gm metadata.Overmap
 func BenchmarkSaveBuild(b *testing.B) {
 	var s save.Save
 	for n := 0; n < b.N; n++ {
-		s, _ = save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard")
+		s, _ = save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard", save.Strict, nil)
 	}
 	gs = s
 }
 
 func BenchmarkMetadatadBuild(b *testing.B) {
-	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard")
+	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard", save.Strict, nil)
 	b.ResetTimer()
 
 	var m metadata.Overmap
 	for n := 0; n < b.N; n++ {
-		m, _ = metadata.Build(s, "/Users/jj/code/Cataclysm-DDA")
+		m, _ = metadata.Build(s, "/Users/jj/code/Cataclysm-DDA", nil, nil)
 	}
 	gm = m
 }
 
 func BenchmarkWorldBuild(b *testing.B) {
-	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard")
-	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA")
+	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard", save.Strict, nil)
+	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA", nil, nil)
 	b.ResetTimer()
 
 	var w world.World
 	for n := 0; n < b.N; n++ {
-		w, _ = world.Build(m, s)
+		w, _ = world.Build(m, s, nil)
 	}
 	gw = w
 }
 
 func BenchmarkRenderTerrainToImages(b *testing.B) {
-	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard")
-	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA")
-	w, _ := world.Build(m, s)
+	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard", save.Strict, nil)
+	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA", nil, nil)
+	w, _ := world.Build(m, s, nil)
 	l := []int{10}
 	b.ResetTimer()
 
 	for n := 0; n < b.N; n++ {
-		render.Image(w, "/Users/jj/Desktop/GoTest", l, true, false, false, true)
+		render.Image(w, "/Users/jj/Desktop/GoTest", l, true, false, false, true, false, false, false, false)
 	}
 }
 
 func BenchmarkRenderSeenToImages(b *testing.B) {
-	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard")
-	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA")
-	w, _ := world.Build(m, s)
+	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard", save.Strict, nil)
+	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA", nil, nil)
+	w, _ := world.Build(m, s, nil)
 	l := []int{10}
 	b.ResetTimer()
 
 	for n := 0; n < b.N; n++ {
-		render.Image(w, "/Users/jj/Desktop/GoTest", l, false, true, false, true)
+		render.Image(w, "/Users/jj/Desktop/GoTest", l, false, true, false, true, false, false, false, false)
 	}
 }
 
 func BenchmarkRenderSeenSolidToImages(b *testing.B) {
-	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard")
-	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA")
-	w, _ := world.Build(m, s)
+	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard", save.Strict, nil)
+	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA", nil, nil)
+	w, _ := world.Build(m, s, nil)
 	l := []int{10}
 	b.ResetTimer()
 
 	for n := 0; n < b.N; n++ {
-		render.Image(w, "/Users/jj/Desktop/GoTest", l, false, false, true, true)
+		render.Image(w, "/Users/jj/Desktop/GoTest", l, false, false, true, true, false, false, false, false)
 	}
 }
 
 func BenchmarkRenderAllToImages(b *testing.B) {
-	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard")
-	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA")
-	w, _ := world.Build(m, s)
+	s, _ := save.Build("/Users/jj/code/Cataclysm-DDA/save/Spenard", save.Strict, nil)
+	m, _ := metadata.Build(s, "/Users/jj/code/Cataclysm-DDA", nil, nil)
+	w, _ := world.Build(m, s, nil)
 	l := []int{10}
 	b.ResetTimer()
 
 	for n := 0; n < b.N; n++ {
-		render.Image(w, "/Users/jj/Desktop/GoTest", l, true, true, true, true)
+		render.Image(w, "/Users/jj/Desktop/GoTest", l, true, true, true, true, false, false, false, false)
 	}
 }
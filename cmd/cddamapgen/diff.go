@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/ralreegorganon/cddamap/internal/gen/render"
+	"github.com/ralreegorganon/cddamap/internal/gen/save"
+	"github.com/ralreegorganon/cddamap/internal/gen/world"
+	log "github.com/sirupsen/logrus"
+)
+
+var diffOpts struct {
+	GameRoot  string   `short:"g" long:"game" required:"true" description:"Cataclysm: DDA game root directory"`
+	From      string   `short:"a" long:"from" required:"true" description:"Older save directory or archive of the world"`
+	To        string   `short:"b" long:"to" required:"true" description:"Newer save directory or archive of the world"`
+	ModDirs   []string `short:"m" long:"moddir" description:"Additional directory to search for mods. Repeat flag for multiple directories."`
+	OutputDir string   `short:"o" long:"output" required:"true" description:"Output folder"`
+	Layers    []int    `short:"l" long:"layer" description:"Layer to compare, 0-20. Repeat flag for multiple layers or omit for all."`
+	Images    bool     `short:"i" long:"images" description:"Render highlight overlay images"`
+	Lenient   bool     `short:"L" long:"lenient" description:"Skip corrupt save files and mark them on the map instead of failing"`
+	CacheDir  string   `short:"K" long:"cache" description:"Directory to cache built terrain metadata in between runs"`
+}
+
+// runDiff implements "cddamapgen diff", which compares two saves of the same
+// world and writes diff.geojson, and optionally overlay images, to the output
+// folder.
+func runDiff(args []string) error {
+	p := flags.NewParser(&diffOpts, flags.Default)
+	p.Usage = "diff [OPTIONS]"
+	_, err := p.ParseArgs(args)
+	if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(diffOpts.Layers) == 0 {
+		for i := 0; i < 21; i++ {
+			diffOpts.Layers = append(diffOpts.Layers, i)
+		}
+	}
+
+	cache := newMetadataCache(diffOpts.GameRoot, diffOpts.ModDirs, diffOpts.CacheDir, false)
+
+	mode := save.Strict
+	if diffOpts.Lenient {
+		mode = save.Lenient
+	}
+
+	build := func(savePath string) (world.World, error) {
+		s, err := save.Build(savePath, mode, nil)
+		if err != nil {
+			return world.World{}, err
+		}
+
+		o, err := cache.get(s, nil)
+		if err != nil {
+			return world.World{}, err
+		}
+
+		return world.Build(o, s, nil)
+	}
+
+	from, err := build(diffOpts.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := build(diffOpts.To)
+	if err != nil {
+		return err
+	}
+
+	d, err := world.Compare(from, to, diffOpts.Layers)
+	if err != nil {
+		return err
+	}
+
+	explored := 0
+	for _, cells := range d.Explored {
+		explored += len(cells)
+	}
+	log.WithFields(log.Fields{
+		"terrain":       len(d.Terrain),
+		"explored":      explored,
+		"citiesAdded":   len(d.CitiesAdded),
+		"citiesRemoved": len(d.CitiesRemoved),
+	}).Info("compared worlds")
+
+	err = os.MkdirAll(diffOpts.OutputDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = render.DiffGeoJSON(d, filepath.Join(diffOpts.OutputDir, "diff.geojson"))
+	if err != nil {
+		return err
+	}
+
+	if diffOpts.Images {
+		err = render.DiffImage(d, to, diffOpts.OutputDir, diffOpts.Layers)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 
 	"net/http"
 	_ "net/http/pprof"
@@ -9,24 +15,44 @@ import (
 	"github.com/jessevdk/go-flags"
 	"github.com/ralreegorganon/cddamap/internal/gen/metadata"
 	"github.com/ralreegorganon/cddamap/internal/gen/render"
+	"github.com/ralreegorganon/cddamap/internal/gen/report"
 	"github.com/ralreegorganon/cddamap/internal/gen/save"
 	"github.com/ralreegorganon/cddamap/internal/gen/world"
 	log "github.com/sirupsen/logrus"
 )
 
 var opts struct {
-	GameRoot           string `short:"g" long:"game" required:"true" description:"Cataclysm: DDA game root directory"`
-	Save               string `short:"s" long:"save" required:"true" description:"Game save directory to process"`
-	OutputDir          string `short:"o" long:"output" required:"true" description:"Output folder"`
-	Text               bool   `short:"t" long:"text" description:"Render to text files"`
-	Images             bool   `short:"i" long:"images" description:"Render to images"`
-	Layers             []int  `short:"l" long:"layer" description:"Layer to render, 0-20. Repeat flag for multiple layers or omit for all."`
-	DBConnectionString string `short:"c" long:"connectionString" description:"PostGIS database connection string"`
-	Terrain            bool   `short:"r" long:"terrain" description:"Render terrain"`
-	Seen               bool   `short:"e" long:"seen" description:"Render seen"`
-	SeenSolid          bool   `short:"d" long:"seensolid" description:"Render seen as a solid overlay"`
-	Cities             bool   `short:"C" long:"cities" description:"Render city names"`
-	SkipEmpty          bool   `short:"k" long:"skipempty" description:"Skip rendering empty layers"`
+	GameRoot           string   `short:"g" long:"game" description:"Cataclysm: DDA game root directory, required unless rendering a --world file"`
+	Save               string   `short:"s" long:"save" description:"Game save directory, or a .zip, .tar or .tar.gz archive of one, to process"`
+	SaveRoot           string   `short:"S" long:"saveroot" description:"Game save root; every world in it is processed into its own folder under the output folder"`
+	World              string   `short:"W" long:"world" description:"World file written by --writeworld to render instead of building a world from a save"`
+	WriteWorld         bool     `short:"w" long:"writeworld" description:"Write the built world to world.bin in the output folder, so it can be rendered elsewhere with --world"`
+	Concurrency        int      `short:"j" long:"concurrency" default:"1" description:"Number of worlds to process at once with --saveroot"`
+	ModDirs            []string `short:"m" long:"moddir" description:"Additional directory to search for mods. Repeat flag for multiple directories."`
+	OutputDir          string   `short:"o" long:"output" required:"true" description:"Output folder"`
+	Text               bool     `short:"t" long:"text" description:"Render to text files"`
+	Images             bool     `short:"i" long:"images" description:"Render to images"`
+	Layers             []int    `short:"l" long:"layer" description:"Layer to render, 0-20. Repeat flag for multiple layers or omit for all."`
+	DBConnectionString string   `short:"c" long:"connectionString" description:"PostGIS database connection string"`
+	Terrain            bool     `short:"r" long:"terrain" description:"Render terrain"`
+	Seen               bool     `short:"e" long:"seen" description:"Render seen"`
+	SeenSolid          bool     `short:"d" long:"seensolid" description:"Render seen as a solid overlay"`
+	Cities             bool     `short:"C" long:"cities" description:"Render city names"`
+	Notes              bool     `short:"N" long:"notes" description:"Render character map notes"`
+	Masked             bool     `short:"M" long:"masked" description:"Render terrain through each character's fog of war"`
+	DimExplored        bool     `short:"D" long:"dimexplored" description:"With --masked, show explored cells that aren't visible as dimmed terrain"`
+	SnapshotTiles      bool     `short:"T" long:"snapshottiles" description:"With a connection string, write output to snapshots/<snapshot ID> in the output folder so every snapshot keeps its own tiles"`
+	CRS                string   `short:"P" long:"crs" default:"pixel" description:"Coordinate system for database and vector output: pixel, the coordinates of the images, or metric, meters on the absolute overmap grid with an overmap tile 288 meters across, or metric:<meters> for another overmap tile size"`
+	SRID               int      `long:"srid" description:"SRID to tag database and vector geometry with"`
+	Export             []string `short:"x" long:"export" description:"Write vector layers without a database: geojson, gpkg or shapefile. Repeat flag for multiple formats. gpkg needs a build with cgo enabled, as its SQLite driver is written in C."`
+	FileStore          bool     `short:"F" long:"filestore" description:"Write the world to world.bin in the output folder along with what cddamap needs to serve it without a database"`
+	Combine            []string `short:"u" long:"combine" description:"Render a combined seen layer: union or intersection, optionally followed by :name,name to pick characters instead of all. Repeat flag for multiple."`
+	SkipEmpty          bool     `short:"k" long:"skipempty" description:"Skip rendering empty layers"`
+	FallbackFont       string   `short:"f" long:"fallbackfont" description:"TrueType font for glyphs missing from Topaz-8, defaults to Go Mono"`
+	Report             bool     `short:"R" long:"report" description:"Write a JSON diagnostics report to report.json in the output folder"`
+	Lenient            bool     `short:"L" long:"lenient" description:"Skip corrupt save files and mark them on the map instead of failing"`
+	CacheDir           string   `short:"K" long:"cache" description:"Directory to cache built terrain metadata in between runs"`
+	InvalidateCache    bool     `short:"I" long:"invalidatecache" description:"Rebuild the terrain metadata cache even if it is up to date"`
 }
 
 func init() {
@@ -43,50 +69,400 @@ func main() {
 		http.ListenAndServe(":8080", nil)
 	}()
 
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		err := runDiff(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	_, err := flags.Parse(&opts)
 	if err != nil {
 		os.Exit(1)
 	}
 
+	sources := 0
+	for _, s := range []string{opts.Save, opts.SaveRoot, opts.World} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		log.Fatal("exactly one of --save, --saveroot or --world is required")
+	}
+
+	if opts.World == "" && opts.GameRoot == "" {
+		log.Fatal("--game is required to build a world from a save")
+	}
+
 	if len(opts.Layers) == 0 {
 		for i := 0; i < 21; i++ {
 			opts.Layers = append(opts.Layers, i)
 		}
 	}
 
-	s, err := save.Build(opts.Save)
+	for _, x := range opts.Export {
+		_, err = render.ParseExportFormat(x)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if opts.FallbackFont != "" {
+		err = render.SetFallbackFont(opts.FallbackFont)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	cache := newMetadataCache(opts.GameRoot, opts.ModDirs, opts.CacheDir, opts.InvalidateCache)
+
+	if opts.Save != "" || opts.World != "" {
+		source := opts.Save
+		if opts.World != "" {
+			source = opts.World
+		}
+		err = processWorld(source, opts.OutputDir, cache)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	worlds, err := discoverWorlds(opts.SaveRoot)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	o, err := metadata.Build(s, opts.GameRoot)
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	sem := make(chan struct{}, opts.Concurrency)
+
+	for _, dir := range worlds {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(dir string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			log.WithField("world", dir).Info("processing world")
+			err := processWorld(dir, filepath.Join(opts.OutputDir, filepath.Base(dir)), cache)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"world": dir,
+					"err":   err,
+				}).Error("couldn't process world")
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(dir)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		log.Fatalf("%v of %v worlds failed", failed, len(worlds))
+	}
+}
+
+func discoverWorlds(saveRoot string) ([]string, error) {
+	entries, err := ioutil.ReadDir(saveRoot)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	w, err := world.Build(o, s)
+	worlds := make([]string, 0)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(saveRoot, e.Name())
+		isWorld := true
+		for _, f := range []string{"worldoptions.json", "mods.json"} {
+			if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+				isWorld = false
+				break
+			}
+		}
+
+		if isWorld {
+			worlds = append(worlds, dir)
+		}
+	}
+
+	if len(worlds) == 0 {
+		return nil, fmt.Errorf("no worlds found in %v", saveRoot)
+	}
+
+	return worlds, nil
+}
+
+type metadataCache struct {
+	gameRoot   string
+	modDirs    []string
+	cacheDir   string
+	invalidate bool
+	mu         sync.Mutex
+	entries    map[string]*metadataCacheEntry
+}
+
+type metadataCacheEntry struct {
+	once sync.Once
+	o    metadata.Overmap
+	err  error
+}
+
+func newMetadataCache(gameRoot string, modDirs []string, cacheDir string, invalidate bool) *metadataCache {
+	return &metadataCache{
+		gameRoot:   gameRoot,
+		modDirs:    modDirs,
+		cacheDir:   cacheDir,
+		invalidate: invalidate,
+		entries:    make(map[string]*metadataCacheEntry),
+	}
+}
+
+// get builds the metadata for a save once per distinct mod list, so worlds
+// sharing a mod list share the result. The diagnostics of the metadata are
+// added to the report of every world that gets it.
+func (c *metadataCache) get(s save.Save, r *report.Report) (metadata.Overmap, error) {
+	key := strings.Join(s.Mods, "\n")
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &metadataCacheEntry{}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+
+	e.once.Do(func() {
+		if c.cacheDir != "" {
+			e.o, e.err = metadata.BuildCached(s, c.gameRoot, c.modDirs, c.cacheDir, c.invalidate, nil)
+			return
+		}
+		e.o, e.err = metadata.Build(s, c.gameRoot, c.modDirs, nil)
+	})
+	if e.err != nil {
+		return e.o, e.err
+	}
+
+	e.o.Report(r)
+	return e.o, nil
+}
+
+// combineSeen adds the seen combination described by spec, such as "union"
+// or "intersection:Alice,Bob", to w.
+func combineSeen(w *world.World, spec string) error {
+	parts := strings.SplitN(spec, ":", 2)
+	mode, err := world.ParseCombineMode(parts[0])
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if opts.Text {
-		err = render.Text(w, opts.OutputDir, opts.Layers, opts.Terrain, opts.Seen, opts.SkipEmpty, opts.Cities)
+	var characters []string
+	if len(parts) == 2 && parts[1] != "" {
+		characters = strings.Split(parts[1], ",")
+	}
+
+	name, err := world.CombineSeen(w, mode, characters)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"name":       name,
+		"characters": w.CombinedSeen[name].Characters,
+	}).Info("combined seen layers")
+	return nil
+}
+
+// processWorld builds the world of a save, or loads it from a world file
+// with --world, and renders it to outputDir.
+func processWorld(source, outputDir string, cache *metadataCache) error {
+	var r *report.Report
+	if opts.Report {
+		r = report.New(filepath.Base(source))
+	}
+
+	var w world.World
+	var err error
+	if opts.World != "" {
+		w, err = loadWorld(source, r)
+	} else {
+		w, err = buildWorld(source, cache, r)
+	}
+	if err == nil {
+		err = renderWorld(w, outputDir, r)
+	}
+	if err != nil {
+		r.Fail(err)
+	}
+
+	if r != nil {
+		werr := os.MkdirAll(outputDir, os.ModePerm)
+		if werr == nil {
+			werr = r.Write(filepath.Join(outputDir, "report.json"))
+		}
+		if werr != nil {
+			log.WithField("err", werr).Error("couldn't write report")
+		}
+	}
+
+	return err
+}
+
+func buildWorld(savePath string, cache *metadataCache, r *report.Report) (world.World, error) {
+	var s save.Save
+	err := r.Stage("save", func() error {
+		var err error
+		mode := save.Strict
+		if opts.Lenient {
+			mode = save.Lenient
+		}
+		s, err = save.Build(savePath, mode, r)
+		return err
+	})
+	if err != nil {
+		return world.World{}, err
+	}
+
+	var o metadata.Overmap
+	err = r.Stage("metadata", func() error {
+		var err error
+		o, err = cache.get(s, r)
+		return err
+	})
+	if err != nil {
+		return world.World{}, err
+	}
+
+	var w world.World
+	err = r.Stage("world", func() error {
+		var err error
+		w, err = world.Build(o, s, r)
+		return err
+	})
+	return w, err
+}
+
+func loadWorld(worldPath string, r *report.Report) (world.World, error) {
+	var w world.World
+	err := r.Stage("world", func() error {
+		var err error
+		w, err = world.ReadFile(worldPath)
+		return err
+	})
+	return w, err
+}
+
+func renderWorld(w world.World, outputDir string, r *report.Report) error {
+	for _, c := range opts.Combine {
+		err := combineSeen(&w, c)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 
-	if opts.Images {
-		err = render.Image(w, opts.OutputDir, opts.Layers, opts.Terrain, opts.Seen, opts.SeenSolid, opts.SkipEmpty, opts.Cities)
+	// A store read along with a world file describes the render it was written
+	// by, not this one.
+	w.Store = nil
+
+	// The world is written before the import, which can move outputDir to a
+	// snapshot folder.
+	if opts.WriteWorld {
+		err := r.Stage("writeworld", func() error {
+			err := os.MkdirAll(outputDir, os.ModePerm)
+			if err != nil {
+				return err
+			}
+			return world.WriteFile(filepath.Join(outputDir, world.FileName), w)
+		})
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+	}
+
+	crs, err := render.ParseCRS(opts.CRS, opts.SRID)
+	if err != nil {
+		return err
+	}
+
+	if r != nil {
+		for glyph, ids := range render.MissingGlyphs(w) {
+			for _, id := range ids {
+				r.AddUnknownSymbol(glyph, id)
+			}
 		}
 	}
 
+	// The import runs first so that, with snapshot tiles, the images can be
+	// written where the snapshot expects its tiles.
 	if opts.DBConnectionString != "" {
-		err = render.GIS(w, opts.DBConnectionString, opts.Layers, opts.Terrain, opts.Seen, opts.SeenSolid, opts.SkipEmpty, opts.Cities)
+		err = r.Stage("gis", func() error {
+			snapshotID, err := render.GIS(w, opts.DBConnectionString, crs, opts.Layers, opts.Terrain, opts.Seen, opts.SeenSolid, opts.SkipEmpty, opts.Cities, opts.Notes, opts.Masked, opts.SnapshotTiles)
+			if err != nil {
+				return err
+			}
+
+			log.WithField("snapshot", snapshotID).Info("imported snapshot")
+			if opts.SnapshotTiles {
+				outputDir = filepath.Join(outputDir, "snapshots", strconv.Itoa(snapshotID))
+			}
+			return nil
+		})
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+	}
+
+	for _, x := range opts.Export {
+		format, err := render.ParseExportFormat(x)
+		if err != nil {
+			return err
+		}
+
+		err = r.Stage("export_"+format.String(), func() error {
+			return render.Export(w, filepath.Join(outputDir, format.String()), format, crs, opts.Layers, opts.Terrain, opts.Seen, opts.Cities, opts.Notes)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.FileStore {
+		err = r.Stage("filestore", func() error {
+			return render.FileStore(w, outputDir, opts.Layers, opts.Terrain, opts.Seen, opts.SeenSolid, opts.SkipEmpty, opts.Cities, opts.Masked)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Text {
+		err = r.Stage("text", func() error {
+			return render.Text(w, outputDir, opts.Layers, opts.Terrain, opts.Seen, opts.SkipEmpty, opts.Cities)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Images {
+		err = r.Stage("images", func() error {
+			return render.Image(w, outputDir, opts.Layers, opts.Terrain, opts.Seen, opts.SeenSolid, opts.SkipEmpty, opts.Cities, opts.Notes, opts.Masked, opts.DimExplored)
+		})
+		if err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
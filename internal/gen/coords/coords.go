@@ -0,0 +1,194 @@
+package coords
+
+import "math"
+
+const (
+	SquaresPerSubmap         = 12
+	SubmapsPerOvermapTerrain = 2
+	OvermapTerrainsPerChunk  = 180
+	SquaresPerOvermapTerrain = SquaresPerSubmap * SubmapsPerOvermapTerrain
+
+	// layer indexes run from z -10 to z 10
+	LayerOffset = 10
+)
+
+// Pixel is a position on the rendered map, in pixels from its top left
+// corner.
+type Pixel struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Cell is a cell of a world's layer grid, relative to the world's origin.
+type Cell struct {
+	X     int `json:"x"`
+	Y     int `json:"y"`
+	Layer int `json:"layer"`
+}
+
+// OvermapTerrain is an absolute overmap terrain tile, the unit the map is
+// drawn in.
+type OvermapTerrain struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	Z int `json:"z"`
+}
+
+// Chunk is an overmap chunk, stored by the game as the o.X.Y file.
+type Chunk struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Submap is an absolute submap, stored by the game in the maps directory.
+type Submap struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	Z int `json:"z"`
+}
+
+// Square is an absolute map square, the tile a creature stands on.
+type Square struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	Z int `json:"z"`
+}
+
+// Grid places a world's cells and pixels in the game. Its origin is the
+// overmap terrain tile of cell 0,0, which is the top left tile of the
+// world's top left chunk.
+type Grid struct {
+	OriginX    int
+	OriginY    int
+	CellWidth  float64
+	CellHeight float64
+}
+
+// FloorDiv divides rounding towards negative infinity, the way the game maps
+// negative coordinates to the next larger unit.
+func FloorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// Layer returns the layer index of a z level.
+func Layer(z int) int {
+	return z + LayerOffset
+}
+
+// Z returns the z level of a layer index.
+func Z(layer int) int {
+	return layer - LayerOffset
+}
+
+// Origin returns the top left overmap terrain tile of the chunk.
+func (c Chunk) Origin() OvermapTerrain {
+	return OvermapTerrain{X: c.X * OvermapTerrainsPerChunk, Y: c.Y * OvermapTerrainsPerChunk}
+}
+
+// Chunk returns the overmap chunk the tile is in.
+func (o OvermapTerrain) Chunk() Chunk {
+	return Chunk{X: FloorDiv(o.X, OvermapTerrainsPerChunk), Y: FloorDiv(o.Y, OvermapTerrainsPerChunk)}
+}
+
+// Local returns the position of the tile within its chunk.
+func (o OvermapTerrain) Local() OvermapTerrain {
+	c := o.Chunk().Origin()
+	return OvermapTerrain{X: o.X - c.X, Y: o.Y - c.Y, Z: o.Z}
+}
+
+// Submap returns the top left submap of the tile.
+func (o OvermapTerrain) Submap() Submap {
+	return Submap{X: o.X * SubmapsPerOvermapTerrain, Y: o.Y * SubmapsPerOvermapTerrain, Z: o.Z}
+}
+
+// Square returns the top left square of the tile.
+func (o OvermapTerrain) Square() Square {
+	return o.Submap().Square()
+}
+
+// OvermapTerrain returns the tile the submap is part of.
+func (s Submap) OvermapTerrain() OvermapTerrain {
+	return OvermapTerrain{X: FloorDiv(s.X, SubmapsPerOvermapTerrain), Y: FloorDiv(s.Y, SubmapsPerOvermapTerrain), Z: s.Z}
+}
+
+// Square returns the top left square of the submap.
+func (s Submap) Square() Square {
+	return Square{X: s.X * SquaresPerSubmap, Y: s.Y * SquaresPerSubmap, Z: s.Z}
+}
+
+// Submap returns the submap the square is in.
+func (s Square) Submap() Submap {
+	return Submap{X: FloorDiv(s.X, SquaresPerSubmap), Y: FloorDiv(s.Y, SquaresPerSubmap), Z: s.Z}
+}
+
+// OvermapTerrain returns the tile the square is in.
+func (s Square) OvermapTerrain() OvermapTerrain {
+	return s.Submap().OvermapTerrain()
+}
+
+// NewGrid returns the grid of a world whose top left chunk is xMin, yMin.
+func NewGrid(xMin, yMin int, cellWidth, cellHeight float64) Grid {
+	o := Chunk{X: xMin, Y: yMin}.Origin()
+	return Grid{OriginX: o.X, OriginY: o.Y, CellWidth: cellWidth, CellHeight: cellHeight}
+}
+
+// OvermapTerrain returns the tile of a cell.
+func (g Grid) OvermapTerrain(c Cell) OvermapTerrain {
+	return OvermapTerrain{X: g.OriginX + c.X, Y: g.OriginY + c.Y, Z: Z(c.Layer)}
+}
+
+// Cell returns the cell of a tile.
+func (g Grid) Cell(o OvermapTerrain) Cell {
+	return Cell{X: o.X - g.OriginX, Y: o.Y - g.OriginY, Layer: Layer(o.Z)}
+}
+
+// Square returns the square under a pixel on the map of z level z.
+func (g Grid) Square(p Pixel, z int) Square {
+	x := int(math.Floor(p.X / g.CellWidth * SquaresPerOvermapTerrain))
+	y := int(math.Floor(p.Y / g.CellHeight * SquaresPerOvermapTerrain))
+	return Square{
+		X: g.OriginX*SquaresPerOvermapTerrain + x,
+		Y: g.OriginY*SquaresPerOvermapTerrain + y,
+		Z: z,
+	}
+}
+
+// Pixel returns the top left pixel of a square.
+func (g Grid) Pixel(s Square) Pixel {
+	x := s.X - g.OriginX*SquaresPerOvermapTerrain
+	y := s.Y - g.OriginY*SquaresPerOvermapTerrain
+	return Pixel{
+		X: float64(x) * g.CellWidth / SquaresPerOvermapTerrain,
+		Y: float64(y) * g.CellHeight / SquaresPerOvermapTerrain,
+	}
+}
+
+// Position is a point of the map in every coordinate system.
+type Position struct {
+	Pixel          Pixel          `json:"pixel"`
+	Cell           Cell           `json:"cell"`
+	OvermapTerrain OvermapTerrain `json:"overmapTerrain"`
+	Chunk          Chunk          `json:"chunk"`
+	ChunkLocal     OvermapTerrain `json:"chunkLocal"`
+	Submap         Submap         `json:"submap"`
+	Square         Square         `json:"square"`
+}
+
+// Position converts a pixel on the map of z level z.
+func (g Grid) Position(p Pixel, z int) Position {
+	s := g.Square(p, z)
+	o := s.OvermapTerrain()
+	return Position{
+		Pixel:          p,
+		Cell:           g.Cell(o),
+		OvermapTerrain: o,
+		Chunk:          o.Chunk(),
+		ChunkLocal:     o.Local(),
+		Submap:         s.Submap(),
+		Square:         s,
+	}
+}
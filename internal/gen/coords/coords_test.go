@@ -0,0 +1,161 @@
+package coords
+
+import (
+	"testing"
+)
+
+func TestFloorDiv(t *testing.T) {
+	tests := []struct {
+		a, b, want int
+	}{
+		{0, 12, 0},
+		{11, 12, 0},
+		{12, 12, 1},
+		{-1, 12, -1},
+		{-12, 12, -1},
+		{-13, 12, -2},
+		{-180, 180, -1},
+		{-181, 180, -2},
+		{5, -2, -3},
+		{-5, -2, 2},
+	}
+
+	for _, tt := range tests {
+		if got := FloorDiv(tt.a, tt.b); got != tt.want {
+			t.Errorf("FloorDiv(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestOvermapTerrainChunk(t *testing.T) {
+	tests := []struct {
+		o     OvermapTerrain
+		chunk Chunk
+		local OvermapTerrain
+	}{
+		{OvermapTerrain{0, 0, 0}, Chunk{0, 0}, OvermapTerrain{0, 0, 0}},
+		{OvermapTerrain{179, 180, 1}, Chunk{0, 1}, OvermapTerrain{179, 0, 1}},
+		{OvermapTerrain{-1, -1, -2}, Chunk{-1, -1}, OvermapTerrain{179, 179, -2}},
+		{OvermapTerrain{-180, -181, 0}, Chunk{-1, -2}, OvermapTerrain{0, 179, 0}},
+		{OvermapTerrain{-360, 361, 0}, Chunk{-2, 2}, OvermapTerrain{0, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.o.Chunk(); got != tt.chunk {
+			t.Errorf("%+v.Chunk() = %+v, want %+v", tt.o, got, tt.chunk)
+		}
+		if got := tt.o.Local(); got != tt.local {
+			t.Errorf("%+v.Local() = %+v, want %+v", tt.o, got, tt.local)
+		}
+	}
+}
+
+func TestSquare(t *testing.T) {
+	tests := []struct {
+		s      Square
+		submap Submap
+		o      OvermapTerrain
+	}{
+		{Square{0, 0, 0}, Submap{0, 0, 0}, OvermapTerrain{0, 0, 0}},
+		{Square{23, 24, 1}, Submap{1, 2, 1}, OvermapTerrain{0, 1, 1}},
+		{Square{-1, -1, 0}, Submap{-1, -1, 0}, OvermapTerrain{-1, -1, 0}},
+		{Square{-12, -13, 0}, Submap{-1, -2, 0}, OvermapTerrain{-1, -1, 0}},
+		{Square{-24, -25, -3}, Submap{-2, -3, -3}, OvermapTerrain{-1, -2, -3}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.s.Submap(); got != tt.submap {
+			t.Errorf("%+v.Submap() = %+v, want %+v", tt.s, got, tt.submap)
+		}
+		if got := tt.s.OvermapTerrain(); got != tt.o {
+			t.Errorf("%+v.OvermapTerrain() = %+v, want %+v", tt.s, got, tt.o)
+		}
+		if got := tt.o.Square().OvermapTerrain(); got != tt.o {
+			t.Errorf("%+v.Square().OvermapTerrain() = %+v, want %+v", tt.o, got, tt.o)
+		}
+	}
+}
+
+func TestGridPosition(t *testing.T) {
+	tests := []struct {
+		name  string
+		grid  Grid
+		pixel Pixel
+		z     int
+		want  Position
+	}{
+		{
+			name:  "origin",
+			grid:  NewGrid(0, 0, 24, 24),
+			pixel: Pixel{0, 0},
+			want: Position{
+				Pixel:          Pixel{0, 0},
+				Cell:           Cell{0, 0, 10},
+				OvermapTerrain: OvermapTerrain{0, 0, 0},
+				Chunk:          Chunk{0, 0},
+				ChunkLocal:     OvermapTerrain{0, 0, 0},
+				Submap:         Submap{0, 0, 0},
+				Square:         Square{0, 0, 0},
+			},
+		},
+		{
+			name:  "negative chunk",
+			grid:  NewGrid(-1, -2, 24, 24),
+			pixel: Pixel{13.5, 24 * 181},
+			z:     -1,
+			want: Position{
+				Pixel:          Pixel{13.5, 24 * 181},
+				Cell:           Cell{0, 181, 9},
+				OvermapTerrain: OvermapTerrain{-180, -179, -1},
+				Chunk:          Chunk{-1, -1},
+				ChunkLocal:     OvermapTerrain{0, 1, -1},
+				Submap:         Submap{-359, -358, -1},
+				Square:         Square{-4307, -4296, -1},
+			},
+		},
+		{
+			name:  "last square before the origin of chunk 0",
+			grid:  NewGrid(-1, 0, 24, 24),
+			pixel: Pixel{24*180 - 0.5, 0},
+			z:     2,
+			want: Position{
+				Pixel:          Pixel{24*180 - 0.5, 0},
+				Cell:           Cell{179, 0, 12},
+				OvermapTerrain: OvermapTerrain{-1, 0, 2},
+				Chunk:          Chunk{-1, 0},
+				ChunkLocal:     OvermapTerrain{179, 0, 2},
+				Submap:         Submap{-1, 0, 2},
+				Square:         Square{-1, 0, 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.grid.Position(tt.pixel, tt.z); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGridPixelRoundTrip(t *testing.T) {
+	g := NewGrid(-2, -1, 21.3594, 24)
+	squares := []Square{
+		{g.OriginX * SquaresPerOvermapTerrain, g.OriginY * SquaresPerOvermapTerrain, 0},
+		{-1, -1, 0},
+		{0, 0, 0},
+		{-4000, 1234, 0},
+		{100, -180 * SquaresPerOvermapTerrain, 0},
+	}
+
+	for _, s := range squares {
+		p := g.Pixel(s)
+		// the middle of the square, so rounding can't land on a neighbor
+		p.X += g.CellWidth / SquaresPerOvermapTerrain / 2
+		p.Y += g.CellHeight / SquaresPerOvermapTerrain / 2
+		if got := g.Square(p, s.Z); got != s {
+			t.Errorf("Square(Pixel(%+v)) = %+v", s, got)
+		}
+	}
+}
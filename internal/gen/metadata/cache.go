@@ -0,0 +1,154 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ralreegorganon/cddamap/internal/gen/report"
+	"github.com/ralreegorganon/cddamap/internal/gen/save"
+	log "github.com/sirupsen/logrus"
+)
+
+// cacheFormat is part of every cache key; bump it whenever overmapTerrain or
+// the way it is built changes so stale cache files are ignored.
+const cacheFormat = 3
+
+type cachedOvermap struct {
+	Terrain  map[string]overmapTerrain
+	Specials map[string]string
+	Skipped  skipped
+}
+
+// BuildCached is Build backed by a cache file in cacheDir. The cache key is a
+// hash of the game version, the save's mod list and the path, size and
+// modification time of every source file, so changing any of them rebuilds
+// the cache. invalidate forces a rebuild.
+func BuildCached(save save.Save, gameRoot string, userModDirs []string, cacheDir string, invalidate bool, r *report.Report) (Overmap, error) {
+	jsonRoot := filepath.Join(gameRoot, "data", "json")
+	files, err := overmapTerrainSourceFiles(jsonRoot, modRoots(gameRoot, userModDirs), save.Mods)
+	if err != nil {
+		return Overmap{}, err
+	}
+
+	key, err := cacheKey(gameRoot, save.Mods, files)
+	if err != nil {
+		return Overmap{}, err
+	}
+	file := filepath.Join(cacheDir, fmt.Sprintf("metadata-%v.gob", key))
+
+	if !invalidate {
+		o, err := readCache(file)
+		if err == nil {
+			log.WithField("file", file).Info("using cached metadata")
+			o.Report(r)
+			return o, nil
+		}
+		if !os.IsNotExist(err) {
+			log.WithFields(log.Fields{
+				"file": file,
+				"err":  err,
+			}).Warn("ignoring unreadable metadata cache")
+		}
+	}
+
+	o, err := buildFromFiles(files, r)
+	if err != nil {
+		return o, err
+	}
+
+	err = writeCache(file, o)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"file": file,
+			"err":  err,
+		}).Warn("couldn't write metadata cache")
+	}
+
+	return o, nil
+}
+
+func cacheKey(gameRoot string, mods []string, files []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "format %v\n", cacheFormat)
+
+	version, err := ioutil.ReadFile(filepath.Join(gameRoot, "VERSION.txt"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	fmt.Fprintf(h, "version %q\n", version)
+
+	for _, m := range mods {
+		fmt.Fprintf(h, "mod %q\n", m)
+	}
+
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file %q %v %v\n", abs, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readCache(file string) (Overmap, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Overmap{}, err
+	}
+	defer f.Close()
+
+	var c cachedOvermap
+	err = gob.NewDecoder(f).Decode(&c)
+	if err != nil {
+		return Overmap{}, err
+	}
+
+	for id, t := range c.Terrain {
+		t.ID = id
+		t.internalID = save.HashTerrainID(id)
+		c.Terrain[id] = t
+	}
+
+	return Overmap{built: c.Terrain, specials: c.Specials, skipped: c.Skipped}, nil
+}
+
+// writeCache writes through a temporary file so concurrent runs never see a
+// partially written cache.
+func writeCache(file string, o Overmap) error {
+	err := os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	err = encodeCache(tmp, o)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+func encodeCache(w io.Writer, o Overmap) error {
+	return gob.NewEncoder(w).Encode(cachedOvermap{Terrain: o.built, Specials: o.specials, Skipped: o.skipped})
+}
@@ -3,33 +3,106 @@ package metadata
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"image/color"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/imdario/mergo"
+	"github.com/ralreegorganon/cddamap/internal/gen/report"
 	"github.com/ralreegorganon/cddamap/internal/gen/save"
 	log "github.com/sirupsen/logrus"
 )
 
 type overmapTerrain struct {
 	internalID uint32
-	ID         string   `json:"id"`
-	Type       string   `json:"type"`
-	Abstract   string   `json:"abstract"`
-	Name       string   `json:"name"`
-	Sym        int      `json:"sym"`
-	Color      string   `json:"color"`
-	CopyFrom   string   `json:"copy-from"`
-	SeeCost    int      `json:"see_cost"`
-	Extras     string   `json:"extras"`
-	MonDensity int      `json:"mondensity"`
-	Flags      []string `json:"flags"`
-	Spawns     spawns   `json:"spawns"`
-	MapGen     []mapGen `json:"mapgen"`
+	ID         string      `json:"-"`
+	Type       string      `json:"type"`
+	Abstract   string      `json:"abstract"`
+	Name       translation `json:"name"`
+	Sym        symbol      `json:"sym"`
+	Color      string      `json:"color"`
+	CopyFrom   string      `json:"copy-from"`
+	LooksLike  string      `json:"looks_like"`
+	SeeCost    int         `json:"see_cost"`
+	Extras     string      `json:"extras"`
+	MonDensity int         `json:"mondensity"`
+	Flags      []string    `json:"flags"`
+	Spawns     spawns      `json:"spawns"`
+	MapGen     []mapGen    `json:"mapgen"`
+}
+
+type idList []string
+
+func (l *idList) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*l = idList{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return fmt.Errorf("id must be a string or an array of strings: %v", err)
+	}
+	*l = idList(multiple)
+	return nil
+}
+
+type translation string
+
+func (t *translation) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = translation(s)
+		return nil
+	}
+
+	var o struct {
+		Str   string `json:"str"`
+		StrSp string `json:"str_sp"`
+	}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return fmt.Errorf("name must be a string or an object with str: %v", err)
+	}
+	if o.Str != "" {
+		*t = translation(o.Str)
+	} else {
+		*t = translation(o.StrSp)
+	}
+	return nil
+}
+
+type symbol string
+
+func (s *symbol) UnmarshalJSON(b []byte) error {
+	var code int
+	if err := json.Unmarshal(b, &code); err == nil {
+		if sym, ok := symbols[code]; ok {
+			*s = sym
+			return nil
+		}
+		if code > 0 && utf8.ValidRune(rune(code)) {
+			*s = symbol(rune(code))
+		}
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("sym must be a number or a string: %v", err)
+	}
+	if sym, ok := lineDrawingSymbols[str]; ok {
+		*s = sym
+		return nil
+	}
+	if utf8.ValidString(str) {
+		*s = symbol(str)
+	}
+	return nil
 }
 
 type spawns struct {
@@ -53,12 +126,19 @@ type placeItem struct {
 	Chance int    `json:"chance"`
 }
 
-type modInfo struct {
-	Ident string `json:"ident"`
+// overmapSpecial covers both overmap_special and city_building, which lay
+// out the terrain of a multi-tile building the same way.
+type overmapSpecial struct {
+	ID       string `json:"id"`
+	Overmaps []struct {
+		Overmap string `json:"overmap"`
+	} `json:"overmaps"`
 }
 
 const overmapTerrainTypeID = "overmap_terrain"
 
+var overmapSpecialTypeIDs = []string{"overmap_special", "city_building"}
+
 type inLoadOrder []string
 
 func (s inLoadOrder) Len() int {
@@ -79,7 +159,7 @@ func (s inLoadOrder) Less(i, j int) bool {
 	return c1 < c2
 }
 
-func indexOf(slice []int, item int) int {
+func indexOf(slice []symbol, item symbol) int {
 	for i := range slice {
 		if slice[i] == item {
 			return i
@@ -106,23 +186,23 @@ var linearSuffixes = []string{
 	"_esw",
 	"_nesw"}
 
-var linearSuffixSymbols = map[string]int{
-	"_isolated":  0,
-	"_end_south": 4194424,
-	"_end_west":  4194417,
-	"_ne":        4194413,
-	"_end_north": 4194424,
-	"_ns":        4194424,
-	"_es":        4194412,
-	"_nes":       4194420,
-	"_end_east":  4194417,
-	"_wn":        4194410,
-	"_ew":        4194417,
-	"_new":       4194422,
-	"_sw":        4194411,
-	"_nsw":       4194421,
-	"_esw":       4194423,
-	"_nesw":      4194414,
+var linearSuffixSymbols = map[string]symbol{
+	"_isolated":  "",
+	"_end_south": "\u2502",
+	"_end_west":  "\u2500",
+	"_ne":        "\u2514",
+	"_end_north": "\u2502",
+	"_ns":        "\u2502",
+	"_es":        "\u250c",
+	"_nes":       "\u251c",
+	"_end_east":  "\u2500",
+	"_wn":        "\u2518",
+	"_ew":        "\u2500",
+	"_new":       "\u2534",
+	"_sw":        "\u2510",
+	"_nsw":       "\u2524",
+	"_esw":       "\u252c",
+	"_nesw":      "\u253c",
 }
 
 var rotationSuffixes = []string{
@@ -131,9 +211,11 @@ var rotationSuffixes = []string{
 	"_south",
 	"_west"}
 
-var symbols map[int]string
+var symbols map[int]symbol
+
+var lineDrawingSymbols map[string]symbol
 
-var rotations [][]int
+var rotations [][]symbol
 
 type ColorPair struct {
 	FG color.RGBA
@@ -143,7 +225,7 @@ type ColorPair struct {
 var colors map[string]ColorPair
 
 func init() {
-	symbols = map[int]string{
+	symbols = map[int]symbol{
 		4194424: "\u2502",
 		4194417: "\u2500",
 		4194413: "\u2514",
@@ -157,15 +239,25 @@ func init() {
 		4194414: "\u253c",
 	}
 
-	for i := 0; i < 128; i++ {
-		symbols[i] = string(i)
+	lineDrawingSymbols = map[string]symbol{
+		"LINE_XOXO": "\u2502",
+		"LINE_OXOX": "\u2500",
+		"LINE_XXOO": "\u2514",
+		"LINE_OXXO": "\u250c",
+		"LINE_OOXX": "\u2510",
+		"LINE_XOOX": "\u2518",
+		"LINE_XXXO": "\u251c",
+		"LINE_XXOX": "\u2534",
+		"LINE_XOXX": "\u2524",
+		"LINE_OXXX": "\u252c",
+		"LINE_XXXX": "\u253c",
 	}
 
-	rotations = make([][]int, 0)
-	rotations = append(rotations, []int{60, 94, 62, 118})
-	rotations = append(rotations, []int{4194410, 4194413, 4194412, 4194411})
-	rotations = append(rotations, []int{4194417, 4194424, 4194417, 4194424})
-	rotations = append(rotations, []int{4194420, 4194423, 4194421, 4194422})
+	rotations = make([][]symbol, 0)
+	rotations = append(rotations, []symbol{"<", "^", ">", "v"})
+	rotations = append(rotations, []symbol{"\u2518", "\u2514", "\u250c", "\u2510"})
+	rotations = append(rotations, []symbol{"\u2500", "\u2502", "\u2500", "\u2502"})
+	rotations = append(rotations, []symbol{"\u251c", "\u252c", "\u2524", "\u2534"})
 
 	white := color.RGBA{150, 150, 150, 255}
 	black := color.RGBA{0, 0, 0, 255}
@@ -228,8 +320,33 @@ func init() {
 	colors["unset"] = ColorPair{FG: white, BG: black}
 }
 
+// NamedColor returns the foreground and background of a game color name such
+// as "light_red".
+func NamedColor(name string) (color.RGBA, color.RGBA, bool) {
+	cp, ok := colors[name]
+	return cp.FG, cp.BG, ok
+}
+
 type Overmap struct {
-	built map[string]overmapTerrain
+	built    map[string]overmapTerrain
+	specials map[string]string
+	skipped  skipped
+}
+
+// skipped are the files and entries that couldn't be loaded. They're kept
+// with the metadata, so that every report built from it lists them, even when
+// the metadata is shared between worlds or read from the cache.
+type skipped struct {
+	Files   []report.SkippedFile
+	Entries []report.SkippedEntry
+}
+
+func (s *skipped) file(path, reason string) {
+	s.Files = append(s.Files, report.SkippedFile{Path: path, Reason: reason})
+}
+
+func (s *skipped) entry(path string, entry int, reason string) {
+	s.Entries = append(s.Entries, report.SkippedEntry{Path: path, Entry: entry, Reason: reason})
 }
 
 func (o Overmap) UID(id string) uint32 {
@@ -246,8 +363,8 @@ func (o Overmap) Exists(id string) bool {
 
 func (o Overmap) Symbol(id string) string {
 	if t, tok := o.built[id]; tok {
-		if s, sok := symbols[t.Sym]; sok {
-			return s
+		if t.Sym != "" {
+			return string(t.Sym)
 		}
 	}
 	return "?"
@@ -265,36 +382,41 @@ func (o Overmap) Color(id string) (color.RGBA, color.RGBA) {
 
 func (o Overmap) Name(id string) string {
 	if t, tok := o.built[id]; tok {
-		return t.Name
+		return string(t.Name)
 	}
 	return "?"
 }
 
-func Build(save save.Save, gameRoot string) (Overmap, error) {
-	o := Overmap{}
-
-	jsonRoot := filepath.Join(gameRoot, "data", "json")
-	modsRoot := filepath.Join(gameRoot, "data", "mods")
-	files, err := overmapTerrainSourceFiles(jsonRoot, modsRoot, save.Mods)
-	if err != nil {
-		return o, err
+// Special returns the overmap special or city building that the terrain
+// belongs to, or "" when it belongs to none or to more than one.
+func (o Overmap) Special(id string) string {
+	if sp, ok := o.specials[id]; ok {
+		return sp
 	}
+	return o.specials[trimRotation(id)]
+}
 
-	templates := make(map[string]overmapTerrain)
-	for _, f := range files {
-		err = loadTemplates(f, templates)
-		if err != nil {
-			return o, err
+func trimRotation(id string) string {
+	for _, suffix := range rotationSuffixes {
+		if strings.HasSuffix(id, suffix) {
+			return strings.TrimSuffix(id, suffix)
 		}
 	}
+	return id
+}
 
-	built, err := buildTemplates(templates)
+func Build(save save.Save, gameRoot string, userModDirs []string, r *report.Report) (Overmap, error) {
+	o := Overmap{}
+
+	jsonRoot := filepath.Join(gameRoot, "data", "json")
+	files, err := overmapTerrainSourceFiles(jsonRoot, modRoots(gameRoot, userModDirs), save.Mods)
 	if err != nil {
 		return o, err
 	}
 
-	o = Overmap{
-		built: built,
+	o, err = buildFromFiles(files, r)
+	if err != nil {
+		return o, err
 	}
 
 	/*
@@ -316,75 +438,132 @@ func Build(save save.Save, gameRoot string) (Overmap, error) {
 	return o, nil
 }
 
-func overmapTerrainSourceFiles(jsonRoot, modsRoot string, saveMods []string) ([]string, error) {
-	files := []string{}
+func modRoots(gameRoot string, userModDirs []string) []string {
+	roots := []string{
+		filepath.Join(gameRoot, "data", "mods"),
+		filepath.Join(gameRoot, "mods"),
+	}
+	return append(roots, userModDirs...)
+}
 
-	err := filepath.Walk(jsonRoot, func(path string, info os.FileInfo, err error) error {
+func buildFromFiles(files []string, r *report.Report) (Overmap, error) {
+	o := Overmap{}
+
+	templates := make(map[string]overmapTerrain)
+	specials := make(map[string][]string)
+	var sk skipped
+	for _, f := range files {
+		err := loadTemplates(f, templates, specials, &sk)
 		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		if strings.HasSuffix(path, ".json") {
-			files = append(files, path)
+			return o, err
 		}
-		return nil
-	})
+	}
+
+	built, err := buildTemplates(templates)
 	if err != nil {
-		return nil, err
+		return o, err
 	}
 
-	activeMods := map[string]string{}
-	for _, m := range saveMods {
-		activeMods[m] = m
+	o = Overmap{
+		built:    built,
+		specials: specialsByTerrain(specials),
+		skipped:  sk,
 	}
+	o.Report(r)
 
-	mods, err := ioutil.ReadDir(modsRoot)
-	if err != nil {
-		return nil, err
+	return o, nil
+}
+
+// specialsByTerrain inverts special ID to terrain, leaving out terrain such
+// as roads and fields that more than one special uses.
+func specialsByTerrain(specials map[string][]string) map[string]string {
+	owners := make(map[string]map[string]bool)
+	for id, terrain := range specials {
+		for _, t := range terrain {
+			if owners[t] == nil {
+				owners[t] = make(map[string]bool)
+			}
+			owners[t][id] = true
+		}
 	}
 
-	for _, f := range mods {
-		if !f.IsDir() {
+	byTerrain := make(map[string]string)
+	for t, ids := range owners {
+		if len(ids) != 1 {
 			continue
 		}
+		for id := range ids {
+			byTerrain[t] = id
+		}
+	}
+	return byTerrain
+}
 
-		modInfoPath := filepath.Join(modsRoot, f.Name(), "modinfo.json")
-		b, err := ioutil.ReadFile(modInfoPath)
-		if err != nil {
-			return nil, err
+// Report adds the diagnostics of the metadata to r: the files and entries
+// that were skipped, and terrain with a missing symbol or an unknown color.
+func (o Overmap) Report(r *report.Report) {
+	for _, f := range o.skipped.Files {
+		r.SkipFile(f.Path, f.Reason)
+	}
+	for _, e := range o.skipped.Entries {
+		r.SkipEntry(e.Path, e.Entry, e.Reason)
+	}
+
+	for id, t := range o.built {
+		if t.Sym == "" {
+			r.AddMissingSymbol(id)
 		}
-		var modinfo []modInfo
-		err = json.Unmarshal(b, &modinfo)
-		if err != nil {
-			return nil, err
+		if _, ok := colors[t.Color]; t.Color != "" && !ok {
+			r.AddUnknownColor(t.Color, id)
 		}
+	}
+}
 
-		if _, ok := activeMods[modinfo[0].Ident]; !ok {
-			continue
+func overmapTerrainSourceFiles(jsonRoot string, modRoots []string, saveMods []string) ([]string, error) {
+	available, err := discoverMods(modRoots)
+	if err != nil {
+		return nil, err
+	}
+
+	mods, err := resolveLoadOrder(available, saveMods)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := []string{jsonRoot}
+	for _, m := range mods {
+		log.WithFields(log.Fields{
+			"mod":  m.ident(),
+			"path": m.contentRoot(),
+		}).Info("loading mod")
+		roots = append(roots, m.contentRoot())
+	}
+
+	files := []string{}
+	loaded := make(map[string]bool)
+	for _, r := range roots {
+		rootFiles, err := jsonFiles(r)
+		if err != nil {
+			return nil, err
 		}
 
-		err = filepath.Walk(filepath.Join(modsRoot, f.Name()), func(path string, info os.FileInfo, err error) error {
+		for _, f := range rootFiles {
+			abs, err := filepath.Abs(f)
 			if err != nil {
-				return err
-			}
-			if info.IsDir() {
-				return nil
+				return nil, err
 			}
-			if strings.HasSuffix(path, ".json") {
-				files = append(files, path)
+			if loaded[abs] {
+				continue
 			}
-			return nil
-		})
+			loaded[abs] = true
+			files = append(files, f)
+		}
 	}
 
-	sort.Sort(inLoadOrder(files))
-
 	return files, nil
 }
 
-func loadTemplates(file string, templates map[string]overmapTerrain) error {
+func loadTemplates(file string, templates map[string]overmapTerrain, specials map[string][]string, sk *skipped) error {
 	f, err := os.Open(file)
 	if err != nil {
 		return err
@@ -396,116 +575,269 @@ func loadTemplates(file string, templates map[string]overmapTerrain) error {
 		return err
 	}
 
-	if !bytes.Contains(b, []byte(overmapTerrainTypeID)) {
+	relevant := bytes.Contains(b, []byte(overmapTerrainTypeID))
+	for _, t := range overmapSpecialTypeIDs {
+		relevant = relevant || bytes.Contains(b, []byte(t))
+	}
+	if !relevant {
 		return nil
 	}
 
-	var temp []map[string]interface{}
-	err = json.Unmarshal(b, &temp)
-	if err != nil {
-		return err
+	var entries []json.RawMessage
+	if err := json.Unmarshal(b, &entries); err != nil {
+		var single json.RawMessage
+		if err := json.Unmarshal(b, &single); err != nil {
+			log.WithFields(log.Fields{
+				"file": file,
+				"err":  err,
+			}).Warn("skipping malformed json file")
+			sk.file(file, err.Error())
+			return nil
+		}
+		entries = []json.RawMessage{single}
 	}
 
-	filteredOvermapTerrains := make([]map[string]interface{}, 0)
-	for _, t := range temp {
-		if t["type"].(string) == overmapTerrainTypeID {
-			filteredOvermapTerrains = append(filteredOvermapTerrains, t)
+	for i, e := range entries {
+		var header map[string]json.RawMessage
+		if err := json.Unmarshal(e, &header); err != nil {
+			log.WithFields(log.Fields{
+				"file":  file,
+				"entry": i,
+				"err":   err,
+			}).Warn("skipping entry that is not an object")
+			sk.entry(file, i, err.Error())
+			continue
 		}
-	}
 
-	filteredText, err := json.Marshal(filteredOvermapTerrains)
-	if err != nil {
-		return err
-	}
+		var typeID string
+		if err := json.Unmarshal(header["type"], &typeID); err != nil {
+			log.WithFields(log.Fields{
+				"file":  file,
+				"entry": i,
+			}).Warn("skipping entry without a string type")
+			sk.entry(file, i, "type is missing or not a string")
+			continue
+		}
 
-	var overmapTerrains []overmapTerrain
-	err = json.Unmarshal(filteredText, &overmapTerrains)
-	if err != nil {
-		return err
-	}
+		if isSpecialType(typeID) {
+			var sp overmapSpecial
+			if err := json.Unmarshal(e, &sp); err != nil {
+				log.WithFields(log.Fields{
+					"file":  file,
+					"entry": i,
+					"err":   err,
+				}).Warn("skipping malformed " + typeID)
+				sk.entry(file, i, err.Error())
+				continue
+			}
+
+			if sp.ID == "" || len(sp.Overmaps) == 0 {
+				continue
+			}
+
+			terrain := make([]string, 0, len(sp.Overmaps))
+			for _, om := range sp.Overmaps {
+				if om.Overmap != "" {
+					terrain = append(terrain, trimRotation(om.Overmap))
+				}
+			}
+			specials[sp.ID] = terrain
+			continue
+		}
 
-	for _, ot := range overmapTerrains {
-		if ot.Type != overmapTerrainTypeID {
+		if typeID != overmapTerrainTypeID {
 			continue
 		}
+
+		var ot struct {
+			overmapTerrain
+			IDs idList `json:"id"`
+		}
+		if err := json.Unmarshal(e, &ot); err != nil {
+			log.WithFields(log.Fields{
+				"file":  file,
+				"entry": i,
+				"err":   err,
+			}).Warn("skipping malformed overmap_terrain")
+			sk.entry(file, i, err.Error())
+			continue
+		}
+
+		if ot.Abstract == "" && len(ot.IDs) == 0 {
+			log.WithFields(log.Fields{
+				"file":  file,
+				"entry": i,
+			}).Warn("skipping overmap_terrain without an id or abstract")
+			sk.entry(file, i, "overmap_terrain without an id or abstract")
+			continue
+		}
+
 		if ot.Abstract != "" {
-			templates[ot.Abstract] = ot
-		} else {
-			templates[ot.ID] = ot
+			templates[ot.Abstract] = ot.overmapTerrain
+		}
+
+		for _, id := range ot.IDs {
+			t := ot.overmapTerrain
+			t.ID = id
+			t.Abstract = ""
+			templates[id] = t
 		}
 	}
 
 	return nil
 }
 
+func isSpecialType(typeID string) bool {
+	for _, t := range overmapSpecialTypeIDs {
+		if typeID == t {
+			return true
+		}
+	}
+	return false
+}
+
 func buildTemplates(templates map[string]overmapTerrain) (map[string]overmapTerrain, error) {
-	built := make(map[string]overmapTerrain)
+	merged := make(map[string]overmapTerrain)
+
+	for key, ot := range templates {
+		if ot.Abstract != "" {
+			continue
+		}
 
-	for _, ot := range templates {
 		bt := make([]overmapTerrain, 0)
 		t := ot
 		bt = append(bt, t)
+		chain := map[string]bool{key: true}
 		for t.CopyFrom != "" {
-			t = templates[t.CopyFrom]
-			t.internalID = save.HashTerrainID(t.ID)
+			parent, ok := templates[t.CopyFrom]
+			if !ok {
+				log.WithFields(log.Fields{
+					"id":        key,
+					"copy-from": t.CopyFrom,
+				}).Warn("copy-from target not found")
+				break
+			}
+			if chain[t.CopyFrom] {
+				log.WithFields(log.Fields{
+					"id":        key,
+					"copy-from": t.CopyFrom,
+				}).Warn("circular copy-from")
+				break
+			}
+			chain[t.CopyFrom] = true
+			t = parent
 			bt = append(bt, t)
 		}
 
 		b := overmapTerrain{}
 		for i := len(bt) - 1; i >= 0; i-- {
 			if err := mergo.Merge(&b, bt[i], mergo.WithOverride); err != nil {
-				return built, err
+				return nil, err
 			}
 		}
 
-		if ot.Abstract == "" {
-			b.Abstract = ""
-			b.CopyFrom = ""
-			b.internalID = save.HashTerrainID(b.ID)
-			built[b.ID] = b
-
-			rotate := true
-
-			if b.Flags != nil {
-				for _, f := range b.Flags {
-					if f == "NO_ROTATE" {
-						rotate = false
-					} else if f == "LINEAR" {
-						for _, suffix := range linearSuffixes {
-							bs := overmapTerrain{}
-							if err := mergo.Merge(&bs, b, mergo.WithOverride); err != nil {
-								return built, err
-							}
-							bs.ID = b.ID + suffix
-							bs.Sym = linearSuffixSymbols[suffix]
-							b.internalID = save.HashTerrainID(b.ID)
-							built[bs.ID] = bs
+		b.ID = key
+		b.Abstract = ""
+		b.CopyFrom = ""
+		b.internalID = save.HashTerrainID(b.ID)
+		merged[key] = b
+	}
+
+	for id, b := range merged {
+		merged[id] = resolveLooksLike(merged, b)
+	}
+
+	built := make(map[string]overmapTerrain)
+
+	for _, b := range merged {
+		built[b.ID] = b
+
+		rotate := true
+
+		if b.Flags != nil {
+			for _, f := range b.Flags {
+				if f == "NO_ROTATE" {
+					rotate = false
+				} else if f == "LINEAR" {
+					for _, suffix := range linearSuffixes {
+						bs := overmapTerrain{}
+						if err := mergo.Merge(&bs, b, mergo.WithOverride); err != nil {
+							return built, err
 						}
+						bs.ID = b.ID + suffix
+						if sym := linearSuffixSymbols[suffix]; sym != "" {
+							bs.Sym = sym
+						}
+						bs.internalID = save.HashTerrainID(bs.ID)
+						built[bs.ID] = bs
 					}
 				}
 			}
+		}
 
-			if rotate {
-				for i, suffix := range rotationSuffixes {
-					bs := overmapTerrain{}
-					if err := mergo.Merge(&bs, b, mergo.WithOverride); err != nil {
-						log.Fatal(err)
-					}
-					bs.ID = b.ID + suffix
-					b.internalID = save.HashTerrainID(b.ID)
-
-					for _, r := range rotations {
-						index := indexOf(r, b.Sym)
-						if index != -1 {
-							bs.Sym = r[(i+index+4)%4]
-							break
-						}
+		if rotate {
+			for i, suffix := range rotationSuffixes {
+				bs := overmapTerrain{}
+				if err := mergo.Merge(&bs, b, mergo.WithOverride); err != nil {
+					return built, err
+				}
+				bs.ID = b.ID + suffix
+				bs.internalID = save.HashTerrainID(bs.ID)
+
+				for _, r := range rotations {
+					index := indexOf(r, b.Sym)
+					if index != -1 {
+						bs.Sym = r[(i+index+4)%4]
+						break
 					}
-					built[bs.ID] = bs
 				}
+				built[bs.ID] = bs
 			}
 		}
 	}
 
 	return built, nil
 }
+
+func resolveLooksLike(merged map[string]overmapTerrain, t overmapTerrain) overmapTerrain {
+	visited := map[string]bool{t.ID: true}
+	next := t.LooksLike
+
+	for next != "" && (t.Sym == "" || t.Color == "") {
+		if visited[next] {
+			log.WithFields(log.Fields{
+				"id":         t.ID,
+				"looks_like": next,
+			}).Warn("circular looks_like")
+			break
+		}
+		visited[next] = true
+
+		other, ok := merged[next]
+		if !ok {
+			for _, suffix := range rotationSuffixes {
+				if strings.HasSuffix(next, suffix) {
+					other, ok = merged[strings.TrimSuffix(next, suffix)]
+					break
+				}
+			}
+		}
+		if !ok {
+			log.WithFields(log.Fields{
+				"id":         t.ID,
+				"looks_like": next,
+			}).Warn("looks_like target not found")
+			break
+		}
+
+		if t.Sym == "" {
+			t.Sym = other.Sym
+		}
+		if t.Color == "" {
+			t.Color = other.Color
+		}
+		next = other.LooksLike
+	}
+
+	return t
+}
@@ -0,0 +1,192 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const modInfoTypeID = "MOD_INFO"
+
+type modInfo struct {
+	Type         string   `json:"type"`
+	ID           string   `json:"id"`
+	Ident        string   `json:"ident"`
+	Name         string   `json:"name"`
+	Category     string   `json:"category"`
+	Dependencies []string `json:"dependencies"`
+	Path         string   `json:"path"`
+	Core         bool     `json:"core"`
+	Obsolete     bool     `json:"obsolete"`
+	root         string
+}
+
+func (m modInfo) ident() string {
+	if m.ID != "" {
+		return m.ID
+	}
+	return m.Ident
+}
+
+func (m modInfo) contentRoot() string {
+	if m.Path == "" {
+		return m.root
+	}
+	return filepath.Join(m.root, m.Path)
+}
+
+func readModInfo(file string) ([]modInfo, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(b, &entries); err != nil {
+		var single json.RawMessage
+		if err := json.Unmarshal(b, &single); err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		entries = []json.RawMessage{single}
+	}
+
+	mods := make([]modInfo, 0)
+	for i, e := range entries {
+		var m modInfo
+		if err := json.Unmarshal(e, &m); err != nil {
+			return nil, fmt.Errorf("%v: entry %v: %v", file, i, err)
+		}
+		if m.Type != modInfoTypeID {
+			continue
+		}
+		if m.ident() == "" {
+			return nil, fmt.Errorf("%v: entry %v: MOD_INFO without an id", file, i)
+		}
+		m.root = filepath.Dir(file)
+		mods = append(mods, m)
+	}
+
+	return mods, nil
+}
+
+func discoverMods(modRoots []string) (map[string]modInfo, error) {
+	available := make(map[string]modInfo)
+
+	for _, root := range modRoots {
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || info.Name() != "modinfo.json" {
+				return nil
+			}
+
+			mods, err := readModInfo(path)
+			if err != nil {
+				return err
+			}
+
+			for _, m := range mods {
+				if existing, ok := available[m.ident()]; ok {
+					log.WithFields(log.Fields{
+						"mod":      m.ident(),
+						"replaced": existing.root,
+						"by":       m.root,
+					}).Warn("duplicate mod definition")
+				}
+				available[m.ident()] = m
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return available, nil
+}
+
+func resolveLoadOrder(available map[string]modInfo, saveMods []string) ([]modInfo, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int)
+	order := make([]modInfo, 0)
+
+	var visit func(ident string, requiredBy string) error
+	visit = func(ident string, requiredBy string) error {
+		switch state[ident] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("mod %q has a circular dependency on %q", requiredBy, ident)
+		}
+
+		m, ok := available[ident]
+		if !ok {
+			if requiredBy == "" {
+				return fmt.Errorf("mod %q is enabled in the save but was not found in any mod directory", ident)
+			}
+			return fmt.Errorf("mod %q depends on %q, which was not found in any mod directory", requiredBy, ident)
+		}
+
+		state[ident] = visiting
+		for _, d := range m.Dependencies {
+			if err := visit(d, ident); err != nil {
+				return err
+			}
+		}
+		state[ident] = visited
+
+		if m.Obsolete {
+			log.WithField("mod", ident).Warn("loading obsolete mod")
+		}
+		order = append(order, m)
+		return nil
+	}
+
+	for _, ident := range saveMods {
+		if err := visit(ident, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func jsonFiles(root string) ([]string, error) {
+	files := []string{}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(path, ".json") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(inLoadOrder(files))
+
+	return files, nil
+}
@@ -0,0 +1,85 @@
+package metadata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveLoadOrder(t *testing.T) {
+	mods := func(deps map[string][]string) map[string]modInfo {
+		available := make(map[string]modInfo)
+		for id, d := range deps {
+			available[id] = modInfo{Type: modInfoTypeID, ID: id, Dependencies: d}
+		}
+		return available
+	}
+
+	tests := []struct {
+		name      string
+		available map[string]modInfo
+		saveMods  []string
+		order     []string
+		err       string
+	}{
+		{
+			name:      "dependencies load first",
+			available: mods(map[string][]string{"dda": nil, "aftershock": {"dda"}}),
+			saveMods:  []string{"aftershock"},
+			order:     []string{"dda", "aftershock"},
+		},
+		{
+			name:      "shared dependency loads once",
+			available: mods(map[string][]string{"dda": nil, "a": {"dda"}, "b": {"dda", "a"}}),
+			saveMods:  []string{"dda", "b", "a"},
+			order:     []string{"dda", "a", "b"},
+		},
+		{
+			name:      "cycle",
+			available: mods(map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}}),
+			saveMods:  []string{"a"},
+			err:       `mod "c" has a circular dependency on "a"`,
+		},
+		{
+			name:      "self dependency",
+			available: mods(map[string][]string{"a": {"a"}}),
+			saveMods:  []string{"a"},
+			err:       `mod "a" has a circular dependency on "a"`,
+		},
+		{
+			name:      "missing save mod",
+			available: mods(map[string][]string{"dda": nil}),
+			saveMods:  []string{"dda", "gone"},
+			err:       `mod "gone" is enabled in the save`,
+		},
+		{
+			name:      "missing dependency",
+			available: mods(map[string][]string{"a": {"gone"}}),
+			saveMods:  []string{"a"},
+			err:       `mod "a" depends on "gone"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := resolveLoadOrder(tt.available, tt.saveMods)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := make([]string, 0, len(order))
+			for _, m := range order {
+				ids = append(ids, m.ident())
+			}
+			if !reflect.DeepEqual(ids, tt.order) {
+				t.Errorf("got order %v, want %v", ids, tt.order)
+			}
+		})
+	}
+}
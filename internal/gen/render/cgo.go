@@ -0,0 +1,8 @@
+//go:build cgo
+// +build cgo
+
+package render
+
+// cgoEnabled is whether this build can use the SQLite driver GeoPackage
+// export needs, which is written in C.
+const cgoEnabled = true
@@ -0,0 +1,104 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ralreegorganon/cddamap/internal/gen/world"
+)
+
+// CRS is the coordinate reference system render.GIS and the exporters write
+// geometry in. The pixel system is the one the images and tiles use, with y
+// growing down. The metric system places cells by their absolute overmap
+// position on a grid of meters with y growing north, an overmap tile being
+// MetersPerOvermapTile across. Either can be tagged with an SRID.
+type CRS struct {
+	Metric               bool
+	MetersPerOvermapTile float64
+	SRID                 int
+}
+
+// defaultMetersPerOvermapTile is the scale of the metric system unless one
+// is given: an overmap tile taken as 24 submaps of 12 meters.
+const defaultMetersPerOvermapTile = 24 * 12
+
+// localMetricWKT describes the metric system to GIS software that reads
+// .prj files and GeoPackage reference systems.
+const localMetricWKT = `LOCAL_CS["Cataclysm: DDA overmap",LOCAL_DATUM["Cataclysm: DDA",0],UNIT["metre",1],AXIS["X",EAST],AXIS["Y",NORTH]]`
+
+// ParseCRS parses pixel, metric, or metric:<meters per overmap tile>.
+func ParseCRS(name string, srid int) (CRS, error) {
+	name, scale, scaled := strings.Cut(name, ":")
+	switch {
+	case (name == "" || name == "pixel") && !scaled:
+		return CRS{SRID: srid}, nil
+	case name == "metric" && !scaled:
+		return CRS{Metric: true, MetersPerOvermapTile: defaultMetersPerOvermapTile, SRID: srid}, nil
+	case name == "metric":
+		m, err := strconv.ParseFloat(scale, 64)
+		if err != nil || m <= 0 || math.IsInf(m, 0) {
+			return CRS{}, fmt.Errorf("invalid metric crs scale %q, expected the meters an overmap tile is across", scale)
+		}
+		return CRS{Metric: true, MetersPerOvermapTile: m, SRID: srid}, nil
+	}
+	return CRS{}, fmt.Errorf("unknown crs %q, expected pixel or metric", name)
+}
+
+// transform is the affine map from the pixel coordinates of a world to a
+// CRS, along with the SRID to tag geometry with.
+type transform struct {
+	srid    int
+	scaleX  float64
+	scaleY  float64
+	offsetX float64
+	offsetY float64
+}
+
+func (c CRS) transform(w world.World) transform {
+	if !c.Metric {
+		return transform{srid: c.SRID, scaleX: 1, scaleY: 1}
+	}
+
+	m := c.MetersPerOvermapTile
+	return transform{
+		srid:    c.SRID,
+		scaleX:  m / cellWidth,
+		scaleY:  -m / float64(cellHeight),
+		offsetX: float64(w.OriginX) * m,
+		offsetY: -float64(w.OriginY) * m,
+	}
+}
+
+func (t transform) apply(p [2]float64) [2]float64 {
+	return [2]float64{p[0]*t.scaleX + t.offsetX, p[1]*t.scaleY + t.offsetY}
+}
+
+// flips reports whether the transform mirrors geometry, which reverses the
+// orientation of rings.
+func (t transform) flips() bool {
+	return t.scaleX*t.scaleY < 0
+}
+
+// cellWidth is how wide a cell is in the CRS.
+func (t transform) cellWidth() float64 {
+	return cellWidth * math.Abs(t.scaleX)
+}
+
+// ewkt tags WKT with the SRID for PostGIS.
+func (t transform) ewkt(wkt string) string {
+	return fmt.Sprintf("SRID=%v;%v", t.srid, wkt)
+}
+
+func (t transform) pointWKT(p [2]float64) string {
+	p = t.apply(p)
+	return fmt.Sprintf("POINT(%f %f)", p[0], p[1])
+}
+
+// rectWKT returns the polygon of the cells from x1, y1 up to x2, y2.
+func (t transform) rectWKT(x1, y1, x2, y2 int) string {
+	a := t.apply([2]float64{float64(x1) * cellWidth, float64(y1) * float64(cellHeight)})
+	b := t.apply([2]float64{float64(x2) * cellWidth, float64(y2) * float64(cellHeight)})
+	return fmt.Sprintf("POLYGON((%[1]f %[2]f, %[3]f %[4]f, %[5]f %[6]f, %[7]f %[8]f, %[1]f %[2]f))", a[0], a[1], b[0], a[1], b[0], b[1], a[0], b[1])
+}
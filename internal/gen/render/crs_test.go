@@ -0,0 +1,60 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/ralreegorganon/cddamap/internal/gen/world"
+)
+
+func TestParseCRS(t *testing.T) {
+	tests := []struct {
+		name string
+		want CRS
+		err  bool
+	}{
+		{name: "", want: CRS{SRID: 3857}},
+		{name: "pixel", want: CRS{SRID: 3857}},
+		{name: "metric", want: CRS{Metric: true, MetersPerOvermapTile: 288, SRID: 3857}},
+		{name: "metric:24", want: CRS{Metric: true, MetersPerOvermapTile: 24, SRID: 3857}},
+		{name: "metric:0", err: true},
+		{name: "metric:", err: true},
+		{name: "metric:wide", err: true},
+		{name: "pixel:2", err: true},
+		{name: "mercator", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseCRS(tt.name, 3857)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseCRS(%q) = %+v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseCRS(%q) = %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestMetricTransform(t *testing.T) {
+	w := world.World{OriginX: -1, OriginY: 2}
+
+	tests := []struct {
+		meters float64
+		pixel  [2]float64
+		want   [2]float64
+	}{
+		{288, [2]float64{0, 0}, [2]float64{-288, -576}},
+		{288, [2]float64{cellWidth, float64(cellHeight)}, [2]float64{0, -864}},
+		{24, [2]float64{cellWidth * 3, float64(cellHeight)}, [2]float64{48, -72}},
+	}
+
+	for _, tt := range tests {
+		c := CRS{Metric: true, MetersPerOvermapTile: tt.meters}
+		got := c.transform(w).apply(tt.pixel)
+		if d := [2]float64{got[0] - tt.want[0], got[1] - tt.want[1]}; d[0]*d[0]+d[1]*d[1] > 1e-9 {
+			t.Errorf("%v meters: apply(%v) = %v, want %v", tt.meters, tt.pixel, got, tt.want)
+		}
+	}
+}
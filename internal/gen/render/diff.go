@@ -0,0 +1,186 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ralreegorganon/cddamap/internal/gen/world"
+)
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// cellPolygon is the same cell outline render.GIS stores.
+func cellPolygon(x, y int) geoJSONGeometry {
+	x1 := float64(x) * cellWidth
+	y1 := float64(y) * float64(cellHeight)
+	x2 := x1 + cellWidth
+	y2 := y1 + float64(cellHeight)
+
+	return geoJSONGeometry{
+		Type:        "Polygon",
+		Coordinates: [][][2]float64{{{x1, y1}, {x2, y1}, {x2, y2}, {x1, y2}, {x1, y1}}},
+	}
+}
+
+func cityPoint(c world.City) geoJSONGeometry {
+	return geoJSONGeometry{
+		Type:        "Point",
+		Coordinates: [2]float64{float64(c.X)*cellWidth + cellWidth/2, float64(c.Y)*float64(cellHeight) + float64(cellHeight)/2},
+	}
+}
+
+// DiffGeoJSON writes a diff to file as a GeoJSON feature collection in the
+// pixel coordinates of the newer build. Every feature has a "kind" of
+// terrain, explored, city_added or city_removed.
+func DiffGeoJSON(d world.Diff, file string) error {
+	fc := geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]geoJSONFeature, 0),
+	}
+
+	for _, t := range d.Terrain {
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: cellPolygon(t.X, t.Y),
+			Properties: map[string]interface{}{
+				"kind":     "terrain",
+				"z":        t.Layer,
+				"overmapX": t.OvermapX,
+				"overmapY": t.OvermapY,
+				"fromId":   t.From.ID,
+				"fromName": t.From.Name,
+				"toId":     t.To.ID,
+				"toName":   t.To.Name,
+			},
+		})
+	}
+
+	for character, cells := range d.Explored {
+		for _, c := range cells {
+			fc.Features = append(fc.Features, geoJSONFeature{
+				Type:     "Feature",
+				Geometry: cellPolygon(c.X, c.Y),
+				Properties: map[string]interface{}{
+					"kind":      "explored",
+					"character": character,
+					"z":         c.Layer,
+					"overmapX":  c.OvermapX,
+					"overmapY":  c.OvermapY,
+				},
+			})
+		}
+	}
+
+	for kind, cities := range map[string][]world.City{"city_added": d.CitiesAdded, "city_removed": d.CitiesRemoved} {
+		for _, c := range cities {
+			fc.Features = append(fc.Features, geoJSONFeature{
+				Type:     "Feature",
+				Geometry: cityPoint(c),
+				Properties: map[string]interface{}{
+					"kind": kind,
+					"name": c.Name,
+					"size": c.Size,
+				},
+			})
+		}
+	}
+
+	b, err := json.Marshal(fc)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+var diffTerrainColor = color.RGBA{255, 140, 0, 200}
+var diffExploredColor = color.RGBA{0, 200, 255, 96}
+var diffCityAddedColor = color.RGBA{0, 255, 0, 255}
+var diffCityRemovedColor = color.RGBA{255, 0, 0, 255}
+
+// DiffImage writes a transparent highlight overlay per layer, diff_<layer>.png,
+// sized like the newer build's other images.
+func DiffImage(d world.Diff, w world.World, outputRoot string, includeLayers []int) error {
+	err := os.MkdirAll(outputRoot, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	if len(includeLayers) == 0 {
+		return nil
+	}
+
+	e := &png.Encoder{
+		BufferPool: &pool{},
+	}
+
+	l := w.TerrainLayers[includeLayers[0]]
+	width := int(cellWidth * float64(len(l.TerrainRows[0].TerrainCellKeys)))
+	height := cellHeight * len(l.TerrainRows)
+	fullImage := image.NewRGBA(image.Rect(0, 0, width, height))
+	u := make(uniforms)
+
+	cellRect := func(x, y int) image.Rectangle {
+		px := int(float64(x) * cellWidth)
+		return image.Rect(px, y*cellHeight, px+cellOverprintWidth, (y+1)*cellHeight)
+	}
+
+	for _, layerID := range includeLayers {
+		draw.Draw(fullImage, fullImage.Bounds(), image.Transparent, image.ZP, draw.Src)
+
+		for _, cells := range d.Explored {
+			for _, c := range cells {
+				if c.Layer == layerID {
+					draw.Draw(fullImage, cellRect(c.X, c.Y), u.get(diffExploredColor), image.ZP, draw.Src)
+				}
+			}
+		}
+
+		for _, t := range d.Terrain {
+			if t.Layer == layerID {
+				draw.Draw(fullImage, cellRect(t.X, t.Y), u.get(diffTerrainColor), image.ZP, draw.Src)
+			}
+		}
+
+		if layerID == 10 {
+			for colorFor, cities := range map[color.RGBA][]world.City{diffCityAddedColor: d.CitiesAdded, diffCityRemovedColor: d.CitiesRemoved} {
+				for _, c := range cities {
+					center := image.Pt(int((float64(c.X)+0.5)*cellWidth), c.Y*cellHeight+cellHeight/2)
+					radius := int(3 * cellWidth)
+					edge := &circle{center, radius, 4}
+					bounds := edge.Bounds().Intersect(fullImage.Bounds())
+					draw.DrawMask(fullImage, bounds, u.get(colorFor), image.ZP, edge, bounds.Min, draw.Over)
+				}
+			}
+		}
+
+		filename := filepath.Join(outputRoot, fmt.Sprintf("diff_%v.png", layerID))
+		err := write(filename, e, fullImage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
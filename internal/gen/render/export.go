@@ -0,0 +1,112 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ralreegorganon/cddamap/internal/gen/world"
+)
+
+type ExportFormat int
+
+const (
+	GeoJSON ExportFormat = iota
+	GeoPackage
+	Shapefile
+)
+
+func (f ExportFormat) String() string {
+	switch f {
+	case GeoPackage:
+		return "gpkg"
+	case Shapefile:
+		return "shapefile"
+	}
+	return "geojson"
+}
+
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch s {
+	case "geojson":
+		return GeoJSON, nil
+	case "gpkg", "geopackage":
+		if !cgoEnabled {
+			return GeoPackage, fmt.Errorf("gpkg export needs cddamapgen built with cgo enabled")
+		}
+		return GeoPackage, nil
+	case "shp", "shapefile":
+		return Shapefile, nil
+	}
+	return GeoJSON, fmt.Errorf("unknown export format %q, expected geojson, gpkg or shapefile", s)
+}
+
+// Export writes terrain, overmap special buildings, seen areas, cities, notes
+// and located characters as vector layers without a database. GeoJSON and
+// Shapefile get a file per layer, such as terrain_10.geojson, and GeoPackage
+// one <world>.gpkg with a table per layer. Terrain and seen areas are merged
+// into a multipolygon per contiguous area like render.GIS does, in the same
+// CRS.
+func Export(w world.World, outputRoot string, format ExportFormat, crs CRS, includeLayers []int, terrain, seen, cities, notes bool) error {
+	err := os.MkdirAll(outputRoot, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	layers := vectorLayers(w, crs.transform(w), includeLayers, terrain, seen, cities, notes)
+
+	switch format {
+	case GeoPackage:
+		return writeGeoPackage(layers, filepath.Join(outputRoot, w.Name+".gpkg"), crs)
+	case Shapefile:
+		for _, l := range layers {
+			err := writeShapefile(l, filepath.Join(outputRoot, l.name), crs)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		for _, l := range layers {
+			err := writeGeoJSON(l, filepath.Join(outputRoot, l.name+".geojson"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeGeoJSON(l vectorLayer, file string) error {
+	fc := geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]geoJSONFeature, 0, len(l.features)),
+	}
+
+	for _, f := range l.features {
+		g := geoJSONGeometry{Type: "MultiPolygon", Coordinates: f.polygons}
+		if l.points {
+			g = geoJSONGeometry{Type: "Point", Coordinates: f.point}
+		}
+
+		properties := make(map[string]interface{})
+		for fi, field := range l.fields {
+			properties[field.name] = f.values[fi]
+		}
+
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   g,
+			Properties: properties,
+		})
+	}
+
+	b, err := json.Marshal(fc)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, b, 0644)
+}
@@ -0,0 +1,112 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/ralreegorganon/cddamap/internal/gen/world"
+)
+
+// FileStore writes the world to its world file in outputRoot along with the
+// store the server needs to serve it without a database. The store lists the
+// same layers GIS would import with the same options, with their tiles where
+// cddamaptiler puts them.
+func FileStore(w world.World, outputRoot string, includeLayers []int, terrain, seen, seenSolid, skipEmpty, cities, masked bool) error {
+	err := os.MkdirAll(outputRoot, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	s := world.Store{
+		MaxZ:       worldZoom(w, includeLayers),
+		CellWidth:  cellWidth,
+		CellHeight: float64(cellHeight),
+		Layers:     make([]world.StoreLayer, 0),
+		Terrain:    make(map[int]world.TerrainIndex),
+	}
+
+	tileRoot := func(base string) string {
+		return path.Join(w.Name, base+"_tiles")
+	}
+
+	for _, i := range includeLayers {
+		if seen || seenSolid || masked {
+			for id, layers := range w.SeenLayers {
+				if layers[i].Empty && skipEmpty {
+					continue
+				}
+
+				types, files := seenLayerTypes(id, "", i, seen, seenSolid, masked)
+				for ti, t := range types {
+					s.Layers = append(s.Layers, world.StoreLayer{Z: i, Type: t, Character: id, TileRoot: tileRoot(files[ti])})
+				}
+			}
+
+			for name, cs := range w.CombinedSeen {
+				if cs.Layers[i].Empty && skipEmpty {
+					continue
+				}
+
+				types, files := seenLayerTypes(name, cs.Mode.String(), i, seen, seenSolid, masked)
+				for ti, t := range types {
+					s.Layers = append(s.Layers, world.StoreLayer{Z: i, Type: t, Name: name, TileRoot: tileRoot(files[ti])})
+				}
+			}
+		}
+
+		if terrain {
+			l := w.TerrainLayers[i]
+
+			if l.Empty && skipEmpty {
+				continue
+			}
+
+			s.Layers = append(s.Layers, world.StoreLayer{Z: i, Type: "overmap", TileRoot: tileRoot(fmt.Sprintf("o_%v", i))})
+			s.Terrain[i] = terrainIndex(w, l)
+		}
+	}
+
+	if cities {
+		s.Layers = append(s.Layers, world.StoreLayer{Z: 10, Type: "city", TileRoot: tileRoot("cities")})
+	}
+
+	w.Store = &s
+	return world.WriteFile(filepath.Join(outputRoot, world.FileName), w)
+}
+
+// terrainIndex points every cell of a terrain layer at the region of equal
+// terrain around it, the same areas GIS stores as cells.
+func terrainIndex(w world.World, l world.TerrainLayer) world.TerrainIndex {
+	height := len(l.TerrainRows)
+	width := 0
+	if height > 0 {
+		width = len(l.TerrainRows[0].TerrainCellKeys)
+	}
+
+	idx := world.TerrainIndex{
+		Width:    width,
+		Height:   height,
+		Regions:  make([]int32, width*height),
+		Outlines: make([][][][][2]int, 0),
+	}
+	for i := range idx.Regions {
+		idx.Regions[i] = -1
+	}
+
+	terrainKey, _ := terrainKeys(w, l)
+
+	for ri, r := range regions(width, height, terrainKey, true) {
+		for _, rect := range r.rects {
+			for y := rect[1]; y < rect[3]; y++ {
+				for x := rect[0]; x < rect[2]; x++ {
+					idx.Regions[y*width+x] = int32(ri)
+				}
+			}
+		}
+		idx.Outlines = append(idx.Outlines, r.polygons)
+	}
+
+	return idx
+}
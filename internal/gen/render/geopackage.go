@@ -0,0 +1,232 @@
+package render
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	wkbPoint        = 1
+	wkbPolygon      = 3
+	wkbMultiPolygon = 6
+)
+
+// geoPackageUndefinedSRS is the undefined Cartesian reference system every
+// GeoPackage has, used when the CRS has no SRID.
+const geoPackageUndefinedSRS = -1
+
+var geoPackageSchema = []string{
+	"pragma application_id = 1196444487",
+	"pragma user_version = 10200",
+	`create table gpkg_spatial_ref_sys (
+		srs_name text not null,
+		srs_id integer primary key,
+		organization text not null,
+		organization_coordsys_id integer not null,
+		definition text not null,
+		description text
+	)`,
+	`insert into gpkg_spatial_ref_sys values
+		('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+		('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system'),
+		('WGS 84 geodetic', 4326, 'EPSG', 4326, 'GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]', 'longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid')`,
+	`create table gpkg_contents (
+		table_name text not null primary key,
+		data_type text not null,
+		identifier text unique,
+		description text default '',
+		last_change datetime not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+		min_x double,
+		min_y double,
+		max_x double,
+		max_y double,
+		srs_id integer,
+		constraint fk_gc_r_srs_id foreign key (srs_id) references gpkg_spatial_ref_sys(srs_id)
+	)`,
+	`create table gpkg_geometry_columns (
+		table_name text not null,
+		column_name text not null,
+		geometry_type_name text not null,
+		srs_id integer not null,
+		z tinyint not null,
+		m tinyint not null,
+		constraint pk_geom_cols primary key (table_name, column_name),
+		constraint fk_gc_tn foreign key (table_name) references gpkg_contents(table_name),
+		constraint fk_gc_srs foreign key (srs_id) references gpkg_spatial_ref_sys(srs_id)
+	)`,
+}
+
+// writeGeoPackage writes every layer as a feature table of a new GeoPackage,
+// replacing the file if it exists.
+func writeGeoPackage(layers []vectorLayer, file string, crs CRS) error {
+	err := os.Remove(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	for _, s := range geoPackageSchema {
+		_, err = txn.Exec(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	srs := geoPackageUndefinedSRS
+	if crs.SRID != 0 {
+		srs = crs.SRID
+	}
+
+	var known int
+	err = txn.QueryRow("select count(*) from gpkg_spatial_ref_sys where srs_id = ?", srs).Scan(&known)
+	if err != nil {
+		return err
+	}
+	if known == 0 {
+		name, definition := "Cataclysm: DDA map pixels", "undefined"
+		if crs.Metric {
+			name, definition = "Cataclysm: DDA overmap meters", localMetricWKT
+		}
+		_, err = txn.Exec("insert into gpkg_spatial_ref_sys values (?, ?, 'NONE', ?, ?, '')", name, srs, srs, definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, l := range layers {
+		err = writeGeoPackageLayer(txn, l, srs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+func writeGeoPackageLayer(txn *sql.Tx, l vectorLayer, srs int) error {
+	geometryType := "MULTIPOLYGON"
+	if l.points {
+		geometryType = "POINT"
+	}
+
+	columns := []string{"fid integer primary key autoincrement", "geom " + geometryType}
+	names := []string{"geom"}
+	placeholders := []string{"?"}
+	for _, f := range l.fields {
+		kind := "text"
+		switch f.kind {
+		case intField:
+			kind = "integer"
+		case boolField:
+			kind = "boolean"
+		}
+		columns = append(columns, fmt.Sprintf("%q %v", f.name, kind))
+		names = append(names, fmt.Sprintf("%q", f.name))
+		placeholders = append(placeholders, "?")
+	}
+
+	_, err := txn.Exec(fmt.Sprintf("create table %q (%v)", l.name, strings.Join(columns, ", ")))
+	if err != nil {
+		return err
+	}
+
+	stmt, err := txn.Prepare(fmt.Sprintf("insert into %q (%v) values (%v)", l.name, strings.Join(names, ", "), strings.Join(placeholders, ", ")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	bbox := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, f := range l.features {
+		var g []byte
+		var fb [4]float64
+		if l.points {
+			g, fb = geoPackagePoint(f.point, srs)
+		} else {
+			g, fb = geoPackageMultiPolygon(f.polygons, srs)
+		}
+		bbox = [4]float64{math.Min(bbox[0], fb[0]), math.Min(bbox[1], fb[1]), math.Max(bbox[2], fb[2]), math.Max(bbox[3], fb[3])}
+
+		args := append([]interface{}{g}, f.values...)
+		_, err = stmt.Exec(args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = txn.Exec("insert into gpkg_contents (table_name, data_type, identifier, min_x, min_y, max_x, max_y, srs_id) values (?, 'features', ?, ?, ?, ?, ?, ?)",
+		l.name, l.name, bbox[0], bbox[1], bbox[2], bbox[3], srs)
+	if err != nil {
+		return err
+	}
+
+	_, err = txn.Exec("insert into gpkg_geometry_columns (table_name, column_name, geometry_type_name, srs_id, z, m) values (?, 'geom', ?, ?, 0, 0)",
+		l.name, geometryType, srs)
+	return err
+}
+
+// geoPackageHeader starts a GeoPackage geometry blob: magic, version, flags
+// for little endian with an optional XY envelope, and the SRS ID.
+func geoPackageHeader(b *bytes.Buffer, srs int, envelope *[4]float64) {
+	flags := byte(0x01)
+	if envelope != nil {
+		flags |= 0x02
+	}
+	b.Write([]byte{'G', 'P', 0, flags})
+	binary.Write(b, binary.LittleEndian, int32(srs))
+	if envelope != nil {
+		binary.Write(b, binary.LittleEndian, [4]float64{envelope[0], envelope[2], envelope[1], envelope[3]})
+	}
+}
+
+func geoPackagePoint(p [2]float64, srs int) ([]byte, [4]float64) {
+	var b bytes.Buffer
+	geoPackageHeader(&b, srs, nil)
+	b.WriteByte(1)
+	binary.Write(&b, binary.LittleEndian, uint32(wkbPoint))
+	binary.Write(&b, binary.LittleEndian, p)
+	return b.Bytes(), [4]float64{p[0], p[1], p[0], p[1]}
+}
+
+func geoPackageMultiPolygon(polygons [][][][2]float64, srs int) ([]byte, [4]float64) {
+	bbox := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range polygons {
+		for _, v := range p[0] {
+			bbox = [4]float64{math.Min(bbox[0], v[0]), math.Min(bbox[1], v[1]), math.Max(bbox[2], v[0]), math.Max(bbox[3], v[1])}
+		}
+	}
+
+	var b bytes.Buffer
+	geoPackageHeader(&b, srs, &bbox)
+	b.WriteByte(1)
+	binary.Write(&b, binary.LittleEndian, uint32(wkbMultiPolygon))
+	binary.Write(&b, binary.LittleEndian, uint32(len(polygons)))
+	for _, p := range polygons {
+		b.WriteByte(1)
+		binary.Write(&b, binary.LittleEndian, uint32(wkbPolygon))
+		binary.Write(&b, binary.LittleEndian, uint32(len(p)))
+		for _, ring := range p {
+			binary.Write(&b, binary.LittleEndian, uint32(len(ring)))
+			binary.Write(&b, binary.LittleEndian, ring)
+		}
+	}
+	return b.Bytes(), bbox
+}
@@ -3,41 +3,100 @@ package render
 import (
 	"database/sql"
 	"fmt"
+	"image/color"
 	"math"
+	"path"
+	"strconv"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
-	"github.com/ralreegorganon/cddamap/internal/gen/save"
 	"github.com/ralreegorganon/cddamap/internal/gen/world"
 )
 
-func GIS(w world.World, connectionString string, includeLayers []int, terrain, seen, seenSolid, skipEmpty, cities bool) error {
-	tl := w.TerrainLayers[includeLayers[0]]
-	width := int(cellWidth * float64(len(tl.TerrainRows[0].TerrainCellKeys)))
-	height := cellHeight * len(tl.TerrainRows)
+// GIS imports the world as a snapshot and returns the snapshot's ID.
+// Cells, cities and seen areas are kept per snapshot, so earlier imports stay
+// queryable. With snapshotTiles, each layer's tiles are expected under
+// <world>/snapshots/<snapshot ID>/ instead of being overwritten in <world>/.
+// Geometry is written in crs, and the snapshot records how to get from it
+// back to the pixel coordinates of the tiles.
+//
+// The import is a single transaction, so a failed run leaves the database as
+// it was. Rerunning the import of the same turn replaces the world's latest
+// snapshot instead of adding another one.
+func GIS(w world.World, connectionString string, crs CRS, includeLayers []int, terrain, seen, seenSolid, skipEmpty, cities, notes, masked, snapshotTiles bool) (int, error) {
+	maxz := worldZoom(w, includeLayers)
 
-	tileXCount := int(math.Ceil(float64(width) / float64(tileSize)))
-	tileYCount := int(math.Ceil(float64(height) / float64(tileSize)))
+	db, err := sqlx.Open("postgres", connectionString)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
 
-	maxz := nativeZoom(tileXCount, tileYCount)
+	txn, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer txn.Rollback()
 
-	db, err := sqlx.Open("postgres", connectionString)
+	gi := &gisImport{
+		txn:           txn,
+		t:             crs.transform(w),
+		snapshotTiles: snapshotTiles,
+	}
+
+	// the upsert locks the world's row until the import commits, so imports of
+	// the same world run one after the other
+	err = txn.QueryRow("insert into world (name, maxz) values ($1, $2) on conflict(name) do update set maxz = EXCLUDED.maxz returning world_id", w.Name, maxz).Scan(&gi.worldID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	var worldID int
-	err = db.QueryRow("insert into world (name, maxz) values ($1, $2) on conflict(name) do update set maxz = EXCLUDED.maxz returning world_id", w.Name, maxz).Scan(&worldID)
+	var turn interface{}
+	for _, c := range w.Characters {
+		if c.Located && (turn == nil || c.Turn > turn.(int)) {
+			turn = c.Turn
+		}
+	}
+
+	err = gi.snapshot(w, turn)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	err = gi.stage()
+	if err != nil {
+		return 0, err
 	}
 
-	emptyRockHash := save.HashTerrainID("empty_rock")
-	openAirHash := save.HashTerrainID("open_air")
-	blankHash := save.HashTerrainID("")
+	tileRoot := func(base string) string {
+		if snapshotTiles {
+			return path.Join(w.Name, "snapshots", strconv.Itoa(gi.snapshotID), base+"_tiles")
+		}
+		return path.Join(w.Name, base+"_tiles")
+	}
+
+	characterIDs := make(map[string]int)
+	for id, c := range w.Characters {
+		characterID, err := gi.upsertCharacter(c)
+		if err != nil {
+			return 0, err
+		}
+		characterIDs[id] = characterID
+	}
+
+	if notes {
+		err = gi.notesToGIS(w, characterIDs, includeLayers)
+		if err != nil {
+			return 0, err
+		}
+	}
 
 	for _, i := range includeLayers {
-		if seen || seenSolid {
+		if seen || seenSolid || masked {
+			// the seen areas of the layer are replaced as a whole, so those of
+			// skipped empty layers and of characters no longer in the save go
+			gi.seenLayers = append(gi.seenLayers, i)
+
 			for name, layers := range w.SeenLayers {
 				l := layers[i]
 
@@ -45,42 +104,51 @@ func GIS(w world.World, connectionString string, includeLayers []int, terrain, s
 					continue
 				}
 
-				var characterID int
-				err = db.QueryRow("select character_id from character where world_id = $1 and namehash = $2", worldID, name).Scan(&characterID)
-				if err == sql.ErrNoRows {
-					err = db.QueryRow("insert into character (world_id, namehash, name) values ($1, $2, $2) returning character_id", worldID, name).Scan(&characterID)
+				characterID, ok := characterIDs[name]
+				if !ok {
+					characterID, err = gi.upsertCharacter(world.Character{ID: name, Name: name})
 					if err != nil {
-						return err
+						return 0, err
 					}
-				} else if err != nil {
-					return err
+					characterIDs[name] = characterID
 				}
 
-				if seen {
-					var layerID int
-					err = db.QueryRow("select layer_id from layer where world_id = $1 and z = $2 and character_id = $3 and type = 'seen'", worldID, i, characterID).Scan(&layerID)
-					if err == sql.ErrNoRows {
-						err = db.QueryRow("insert into layer (world_id, z, character_id, type) values ($1, $2, $3, 'seen') returning layer_id", worldID, i, characterID).Scan(&layerID)
-						if err != nil {
-							return err
-						}
-					} else if err != nil {
-						return err
-					}
+				err = gi.seenToGIS(characterID, i, l)
+				if err != nil {
+					return 0, err
 				}
-				if seenSolid {
-					var layerID int
-					err = db.QueryRow("select layer_id from layer where world_id = $1 and z = $2 and character_id = $3 and type = 'seen_solid'", worldID, i, characterID).Scan(&layerID)
-					if err == sql.ErrNoRows {
-						err = db.QueryRow("insert into layer (world_id, z, character_id, type) values ($1, $2, $3, 'seen_solid') returning layer_id", worldID, i, characterID).Scan(&layerID)
-						if err != nil {
-							return err
-						}
-					} else if err != nil {
-						return err
+
+				types, files := seenLayerTypes(name, "", i, seen, seenSolid, masked)
+				for ti, t := range types {
+					layerID, err := gi.characterLayer(i, characterID, t)
+					if err != nil {
+						return 0, err
+					}
+					err = gi.addLayerSnapshot(layerID, tileRoot(files[ti]))
+					if err != nil {
+						return 0, err
 					}
 				}
+			}
+
+			for name, cs := range w.CombinedSeen {
+				l := cs.Layers[i]
 
+				if l.Empty && skipEmpty {
+					continue
+				}
+
+				types, files := seenLayerTypes(name, cs.Mode.String(), i, seen, seenSolid, masked)
+				for ti, t := range types {
+					layerID, err := gi.layer(i, name, t)
+					if err != nil {
+						return 0, err
+					}
+					err = gi.addLayerSnapshot(layerID, tileRoot(files[ti]))
+					if err != nil {
+						return 0, err
+					}
+				}
 			}
 		}
 
@@ -91,133 +159,444 @@ func GIS(w world.World, connectionString string, includeLayers []int, terrain, s
 				continue
 			}
 
-			var layerID int
-			err = db.QueryRow("select layer_id from layer where world_id = $1 and z = $2 and type = 'overmap'", worldID, i).Scan(&layerID)
-			if err == sql.ErrNoRows {
-				err = db.QueryRow("insert into layer (world_id, z, type) values ($1, $2, 'overmap') returning layer_id", worldID, i).Scan(&layerID)
-				if err != nil {
-					return err
-				}
-			} else if err != nil {
-				return err
-			}
-
-			txn, err := db.Begin()
+			layerID, err := gi.layer(i, "", "overmap")
 			if err != nil {
-				return err
+				return 0, err
 			}
 
-			nukeCellsStmt, err := txn.Prepare("delete from cell where layer_id = $1")
+			err = gi.terrainToGIS(w, l, layerID)
 			if err != nil {
-				return err
+				return 0, err
 			}
 
-			_, err = nukeCellsStmt.Exec(layerID)
+			err = gi.addLayerSnapshot(layerID, tileRoot(fmt.Sprintf("o_%v", i)))
 			if err != nil {
-				return err
+				return 0, err
 			}
+		}
+	}
 
-			stmt, err := txn.Prepare(pq.CopyIn("cell", "layer_id", "id", "name", "the_geom"))
-			if err != nil {
-				return err
-			}
+	if cities {
+		layerID, err := gi.layer(10, "", "city")
+		if err != nil {
+			return 0, err
+		}
 
-			for ri, r := range l.TerrainRows {
-				for ci, k := range r.TerrainCellKeys {
-					if k == emptyRockHash || k == openAirHash || k == blankHash {
-						continue
-					}
+		err = gi.addLayerSnapshot(layerID, tileRoot("cities"))
+		if err != nil {
+			return 0, err
+		}
 
-					x := float64(ci) * cellWidth
-					y := float64(ri) * float64(cellHeight)
-					x2 := x + cellWidth
-					y2 := y + float64(cellHeight)
+		err = gi.citiesToGIS(w)
+		if err != nil {
+			return 0, err
+		}
+	}
 
-					c := w.TerrainCellLookup[k]
+	err = gi.swap()
+	if err != nil {
+		return 0, err
+	}
 
-					geom := fmt.Sprintf("POLYGON((%[1]f %[2]f, %[3]f %[4]f, %[5]f %[6]f, %[7]f %[8]f, %[1]f %[2]f))", x, y, x2, y, x2, y2, x, y2)
-					_, err = stmt.Exec(layerID, c.ID, c.Name, geom)
-					if err != nil {
-						return err
-					}
-				}
-			}
-			_, err = stmt.Exec()
-			if err != nil {
-				return err
-			}
+	err = txn.Commit()
+	if err != nil {
+		return 0, err
+	}
 
-			err = stmt.Close()
-			if err != nil {
-				return err
-			}
+	return gi.snapshotID, nil
+}
 
-			err = txn.Commit()
-			if err != nil {
-				return err
-			}
+// gisImport is an import in progress. Rows are copied into temp staging
+// tables while the world is walked, and only replace what's stored once
+// everything has been staged.
+type gisImport struct {
+	txn        *sqlx.Tx
+	t          transform
+	worldID    int
+	snapshotID int
+	// snapshotTiles is whether the tiles are written to a folder of the
+	// snapshot's own
+	snapshotTiles bool
+
+	// what the staged seen areas, notes and cities replace
+	seenLayers     []int
+	noteCharacters []int
+	noteZ          []int
+	cities         bool
+}
+
+var stagingTables = []string{
+	"create temp table cell_import (layer_id int, id varchar, name varchar, symbol varchar, color_fg varchar, color_bg varchar, cells int, the_geom geometry) on commit drop",
+	"create temp table building_import (layer_id int, special varchar, cells int, the_geom geometry) on commit drop",
+	"create temp table layer_snapshot_import (layer_id int, tile_root varchar, snapshot_tiles boolean) on commit drop",
+	"create temp table seen_import (character_id int, z int, the_geom geometry) on commit drop",
+	"create temp table note_import (character_id int, z int, overmap_x int, overmap_y int, symbol varchar, color varchar, text varchar, dangerous boolean, danger_radius int, the_geom geometry) on commit drop",
+	"create temp table city_import (name varchar, size int, overmap_x int, overmap_y int, the_geom geometry) on commit drop",
+}
+
+func (gi *gisImport) stage() error {
+	for _, q := range stagingTables {
+		_, err := gi.txn.Exec(q)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	if cities {
-		var layerID int
-		err = db.QueryRow("select layer_id from layer where world_id = $1 and z = $2 and type = 'city'", worldID, 10).Scan(&layerID)
-		if err == sql.ErrNoRows {
-			err = db.QueryRow("insert into layer (world_id, z, type) values ($1, $2, 'city') returning layer_id", worldID, 10).Scan(&layerID)
-			if err != nil {
-				return err
-			}
-		} else if err != nil {
+// snapshot picks the snapshot to import into. A rerun of the latest
+// snapshot's turn reuses it, and the swap replaces its rows. Without a
+// located character there is no turn to tell reruns apart, so every such
+// import gets a new snapshot.
+func (gi *gisImport) snapshot(w world.World, turn interface{}) error {
+	t := gi.t
+
+	err := gi.txn.QueryRow(`
+		select
+			snapshot_id
+		from
+			snapshot
+		where
+			snapshot_id = latest_snapshot($1)
+			and turn = $2::int
+	`, gi.worldID, turn).Scan(&gi.snapshotID)
+	if err == sql.ErrNoRows {
+		return gi.txn.QueryRow(`
+			insert into snapshot (world_id, turn, origin_x, origin_y, cell_width, cell_height, srid, scale_x, scale_y, offset_x, offset_y)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			returning snapshot_id`,
+			gi.worldID, turn, w.OriginX, w.OriginY, cellWidth, cellHeight, t.srid, t.scaleX, t.scaleY, t.offsetX, t.offsetY).Scan(&gi.snapshotID)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = gi.txn.Exec(`
+		update snapshot set
+			origin_x = $2,
+			origin_y = $3,
+			cell_width = $4,
+			cell_height = $5,
+			srid = $6,
+			scale_x = $7,
+			scale_y = $8,
+			offset_x = $9,
+			offset_y = $10
+		where
+			snapshot_id = $1`,
+		gi.snapshotID, w.OriginX, w.OriginY, cellWidth, cellHeight, t.srid, t.scaleX, t.scaleY, t.offsetX, t.offsetY)
+	return err
+}
+
+// swap replaces the stored rows with the staged ones. Only the layers this
+// import staged are replaced, so rerunning a reused snapshot with fewer
+// layers keeps the others.
+func (gi *gisImport) swap() error {
+	queries := []string{
+		"delete from cell where snapshot_id = $1 and layer_id in (select layer_id from layer_snapshot_import)",
+		"insert into cell (layer_id, snapshot_id, id, name, symbol, color_fg, color_bg, cells, the_geom) select layer_id, $1, id, name, symbol, color_fg, color_bg, cells, st_multi(st_unaryunion(the_geom)) from cell_import",
+		"delete from building where snapshot_id = $1 and layer_id in (select layer_id from layer_snapshot_import)",
+		"insert into building (layer_id, snapshot_id, special, cells, the_geom) select layer_id, $1, special, cells, st_multi(st_unaryunion(the_geom)) from building_import",
+		"delete from layer_snapshot where snapshot_id = $1 and layer_id in (select layer_id from layer_snapshot_import)",
+		"insert into layer_snapshot (layer_id, snapshot_id, tile_root, snapshot_tiles) select layer_id, $1, tile_root, snapshot_tiles from layer_snapshot_import",
+	}
+	for _, q := range queries {
+		_, err := gi.txn.Exec(q, gi.snapshotID)
+		if err != nil {
 			return err
 		}
+	}
+
+	_, err := gi.txn.Exec("delete from seen where snapshot_id = $1 and z = any($2)", gi.snapshotID, pq.Array(gi.seenLayers))
+	if err != nil {
+		return err
+	}
+	_, err = gi.txn.Exec("insert into seen (character_id, z, snapshot_id, the_geom) select character_id, z, $1, st_multi(st_unaryunion(st_collect(the_geom))) from seen_import group by character_id, z", gi.snapshotID)
+	if err != nil {
+		return err
+	}
 
-		txn, err := db.Begin()
+	if len(gi.noteCharacters) > 0 {
+		_, err = gi.txn.Exec("delete from note where character_id = any($1) and z = any($2)", pq.Array(gi.noteCharacters), pq.Array(gi.noteZ))
 		if err != nil {
 			return err
 		}
-
-		nukeCitiesStmt, err := txn.Prepare("delete from city where world_id = $1")
+		_, err = gi.txn.Exec(`
+			insert into note (character_id, z, overmap_x, overmap_y, symbol, color, text, dangerous, danger_radius, the_geom, danger_geom)
+			select
+				character_id, z, overmap_x, overmap_y, symbol, color, text, dangerous, danger_radius, the_geom,
+				case when dangerous and danger_radius > 0 then st_buffer(the_geom, (danger_radius + 0.5) * $1) end
+			from
+				note_import`, gi.t.cellWidth())
 		if err != nil {
 			return err
 		}
+	}
 
-		_, err = nukeCitiesStmt.Exec(worldID)
+	if gi.cities {
+		_, err = gi.txn.Exec("delete from city where snapshot_id = $1", gi.snapshotID)
 		if err != nil {
 			return err
 		}
-
-		stmt, err := txn.Prepare(pq.CopyIn("city", "world_id", "name", "size", "the_geom"))
+		_, err = gi.txn.Exec("insert into city (world_id, snapshot_id, name, size, overmap_x, overmap_y, the_geom) select $1, $2, name, size, overmap_x, overmap_y, the_geom from city_import", gi.worldID, gi.snapshotID)
 		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// copyIn streams the rows that rows adds into a staging table.
+func (gi *gisImport) copyIn(table string, columns []string, rows func(add func(values ...interface{}) error) error) error {
+	stmt, err := gi.txn.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+
+	err = rows(func(values ...interface{}) error {
+		_, err := stmt.Exec(values...)
+		return err
+	})
+	if err != nil {
+		stmt.Close()
+		return err
+	}
+
+	_, err = stmt.Exec()
+	if err != nil {
+		stmt.Close()
+		return err
+	}
+
+	return stmt.Close()
+}
+
+// layer returns the ID of a layer that doesn't belong to a character,
+// creating it when it's new. Such layers are told apart by name.
+func (gi *gisImport) layer(z int, name, layerType string) (int, error) {
+	var n interface{}
+	if name != "" {
+		n = name
+	}
+
+	var layerID int
+	err := gi.txn.QueryRow(`
+		insert into layer (world_id, z, name, type)
+		values ($1, $2, $3, $4)
+		on conflict (world_id, z, (coalesce(name, '')), type) where character_id is null do update set
+			type = EXCLUDED.type
+		returning layer_id`,
+		gi.worldID, z, n, layerType).Scan(&layerID)
+	return layerID, err
+}
+
+// characterLayer returns the ID of a character's layer, creating it when
+// it's new.
+func (gi *gisImport) characterLayer(z, characterID int, layerType string) (int, error) {
+	var layerID int
+	err := gi.txn.QueryRow(`
+		insert into layer (world_id, z, character_id, type)
+		values ($1, $2, $3, $4)
+		on conflict (world_id, z, character_id, type) do update set
+			type = EXCLUDED.type
+		returning layer_id`,
+		gi.worldID, z, characterID, layerType).Scan(&layerID)
+	return layerID, err
+}
+
+func (gi *gisImport) addLayerSnapshot(layerID int, tileRoot string) error {
+	_, err := gi.txn.Exec("insert into layer_snapshot_import (layer_id, tile_root, snapshot_tiles) values ($1, $2, $3)", layerID, tileRoot, gi.snapshotTiles)
+	return err
+}
+
+// terrainToGIS stores a terrain layer as one multipolygon per contiguous
+// area of a terrain ID, plus one per contiguous building of an overmap
+// special, instead of a polygon per cell.
+func (gi *gisImport) terrainToGIS(w world.World, l world.TerrainLayer, layerID int) error {
+	height := len(l.TerrainRows)
+	width := 0
+	if height > 0 {
+		width = len(l.TerrainRows[0].TerrainCellKeys)
+	}
+
+	terrainKey, specialKey := terrainKeys(w, l)
+
+	cells := make(map[string]world.TerrainCell)
+	for _, c := range w.TerrainCellLookup {
+		cells[c.ID] = c
+	}
+
+	err := gi.copyIn("cell_import", []string{"layer_id", "id", "name", "symbol", "color_fg", "color_bg", "cells", "the_geom"}, func(add func(values ...interface{}) error) error {
+		for _, r := range regions(width, height, terrainKey, false) {
+			c := cells[r.key]
+			err := add(layerID, r.key, c.Name, c.Symbol, hexColor(c.ColorFG), hexColor(c.ColorBG), r.cells, r.wkt(gi.t))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return gi.copyIn("building_import", []string{"layer_id", "special", "cells", "the_geom"}, func(add func(values ...interface{}) error) error {
+		for _, r := range regions(width, height, specialKey, false) {
+			err := add(layerID, r.key, r.cells, r.wkt(gi.t))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// hexColor formats a color the way CSS expects it.
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// notesToGIS stages the notes on the included layers. They replace the
+// characters' stored notes on those layers only. Notes are stored on their
+// game z-level.
+func (gi *gisImport) notesToGIS(w world.World, characterIDs map[string]int, includeLayers []int) error {
+	included := make(map[int]bool)
+	for _, i := range includeLayers {
+		included[i] = true
+		gi.noteZ = append(gi.noteZ, i-10)
+	}
+
+	for name := range w.Notes {
+		gi.noteCharacters = append(gi.noteCharacters, characterIDs[name])
+	}
+
+	return gi.copyIn("note_import", []string{"character_id", "z", "overmap_x", "overmap_y", "symbol", "color", "text", "dangerous", "danger_radius", "the_geom"}, func(add func(values ...interface{}) error) error {
+		for name, notes := range w.Notes {
+			for _, n := range notes {
+				if !included[n.Layer] {
+					continue
+				}
+
+				geom := gi.t.ewkt(gi.t.pointWKT(cellCenter(n.X, n.Y)))
+				err := add(characterIDs[name], n.Z, n.OvermapX, n.OvermapY, n.Symbol, n.ColorName, n.Text, n.Dangerous, n.DangerRadius, geom)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
 
+func (gi *gisImport) citiesToGIS(w world.World) error {
+	gi.cities = true
+
+	return gi.copyIn("city_import", []string{"name", "size", "overmap_x", "overmap_y", "the_geom"}, func(add func(values ...interface{}) error) error {
 		for _, c := range w.CityLayer.Cities {
 			x := float64(c.X)*cellWidth + cellWidth/2
-			y := float64(c.Y)*float64(cellHeight) + float64(cellWidth)/2
+			y := float64(c.Y)*float64(cellHeight) + float64(cellHeight)/2
 
-			geom := fmt.Sprintf("POINT(%[1]f %[2]f)", x, y)
-			_, err = stmt.Exec(worldID, c.Name, c.Size, geom)
+			err := add(c.Name, c.Size, w.OriginX+c.X, w.OriginY+c.Y, gi.t.ewkt(gi.t.pointWKT([2]float64{x, y})))
 			if err != nil {
 				return err
 			}
 		}
-		_, err = stmt.Exec()
-		if err != nil {
-			return err
-		}
+		return nil
+	})
+}
 
-		err = stmt.Close()
-		if err != nil {
-			return err
+// seenToGIS stages a character's seen area on a layer in the snapshot as a
+// single multipolygon, so queries can tell what the character had seen.
+func (gi *gisImport) seenToGIS(characterID, z int, l world.SeenLayer) error {
+	height := len(l.SeenRows)
+	width := 0
+	if height > 0 {
+		width = len(l.SeenRows[0].SeenCellKeys)
+	}
+
+	key := func(x, y int) string {
+		if l.SeenRows[y].SeenCellKeys[x] {
+			return "seen"
 		}
+		return ""
+	}
 
-		err = txn.Commit()
-		if err != nil {
-			return err
+	return gi.copyIn("seen_import", []string{"character_id", "z", "the_geom"}, func(add func(values ...interface{}) error) error {
+		for _, r := range regions(width, height, key, false) {
+			err := add(characterID, z, r.wkt(gi.t))
+			if err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+}
+
+func (gi *gisImport) upsertCharacter(c world.Character) (int, error) {
+	var overmapX, overmapY, z, turn, geom interface{}
+	if c.Located {
+		overmapX = c.OvermapX
+		overmapY = c.OvermapY
+		z = c.Z
+		turn = c.Turn
+		geom = gi.t.ewkt(gi.t.pointWKT(cellCenter(c.X, c.Y)))
 	}
 
-	return nil
+	var characterID int
+	err := gi.txn.QueryRow(`
+		insert into character (world_id, namehash, name, located, overmap_x, overmap_y, z, turn, the_geom)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		on conflict (world_id, namehash) do update set
+			name = EXCLUDED.name,
+			located = EXCLUDED.located,
+			overmap_x = EXCLUDED.overmap_x,
+			overmap_y = EXCLUDED.overmap_y,
+			z = EXCLUDED.z,
+			turn = EXCLUDED.turn,
+			the_geom = EXCLUDED.the_geom
+		returning character_id`,
+		gi.worldID, c.ID, c.Name, c.Located, overmapX, overmapY, z, turn, geom).Scan(&characterID)
+	return characterID, err
+}
+
+// seenLayerTypes returns the types of the layers rendered for a character's
+// seen layer, or for a combined one of the given mode, and the names of their
+// images.
+func seenLayerTypes(name, mode string, i int, seen, seenSolid, masked bool) ([]string, []string) {
+	prefix := "seen"
+	if mode != "" {
+		prefix = "seen_" + mode
+	}
+
+	types := []string{}
+	files := []string{}
+	if seen {
+		types = append(types, prefix)
+		files = append(files, fmt.Sprintf("%v_visible_%v", name, i))
+	}
+	if seenSolid {
+		types = append(types, prefix+"_solid")
+		files = append(files, fmt.Sprintf("%v_visible_solid_%v", name, i))
+	}
+	if masked {
+		types = append(types, "masked")
+		files = append(files, fmt.Sprintf("%v_masked_%v", name, i))
+	}
+	return types, files
+}
+
+// worldZoom returns the tile zoom at which the world's images are shown at
+// their native size.
+func worldZoom(w world.World, includeLayers []int) int {
+	tl := w.TerrainLayers[includeLayers[0]]
+	width := int(cellWidth * float64(len(tl.TerrainRows[0].TerrainCellKeys)))
+	height := cellHeight * len(tl.TerrainRows)
+
+	tileXCount := int(math.Ceil(float64(width) / float64(tileSize)))
+	tileYCount := int(math.Ceil(float64(height) / float64(tileSize)))
+
+	return nativeZoom(tileXCount, tileYCount)
 }
 
 func nativeZoom(xCount, yCount int) int {
@@ -7,13 +7,19 @@ import (
 	"image/color"
 	"image/draw"
 	"image/png"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"unicode/utf8"
 
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
 	"github.com/ralreegorganon/cddamap/internal/gen/world"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/math/fixed"
 )
 
 var dpi = 72.0
@@ -23,7 +29,7 @@ var cellWidth = 21.3594
 var cellHeight = 24
 var cellOverprintWidth = 22
 var mapFont *truetype.Font
-var colorCache map[color.RGBA]*image.Uniform
+var fallbackFont *truetype.Font
 
 func init() {
 	fontBytes, err := Asset("Topaz-8.ttf")
@@ -36,10 +42,117 @@ func init() {
 		panic(err)
 	}
 
-	colorCache = make(map[color.RGBA]*image.Uniform)
+	fallbackFont, err = freetype.ParseFont(gomono.TTF)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// uniforms caches the uniform images of the colors drawn by a single render
+// call, which keeps it free of locking when worlds render concurrently.
+type uniforms map[color.RGBA]*image.Uniform
+
+func (u uniforms) get(c color.RGBA) *image.Uniform {
+	i, ok := u[c]
+	if !ok {
+		i = image.NewUniform(c)
+		u[c] = i
+	}
+	return i
+}
+
+// SetFallbackFont replaces the font used to draw glyphs that are missing from
+// Topaz-8. It defaults to Go Mono.
+func SetFallbackFont(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	f, err := freetype.ParseFont(b)
+	if err != nil {
+		return err
+	}
+
+	fallbackFont = f
+	return nil
+}
+
+func hasGlyphs(f *truetype.Font, s string) bool {
+	for _, r := range s {
+		if f.Index(r) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MissingGlyphs returns the terrain symbols that neither the map font nor the
+// fallback font can draw, along with the terrain IDs that use them.
+func MissingGlyphs(w world.World) map[string][]string {
+	missing := make(map[string][]string)
+
+	for _, cell := range w.TerrainCellLookup {
+		if hasGlyphs(mapFont, cell.Symbol) || hasGlyphs(fallbackFont, cell.Symbol) {
+			continue
+		}
+		missing[cell.Symbol] = append(missing[cell.Symbol], cell.ID)
+	}
+
+	for _, ids := range missing {
+		sort.Strings(ids)
+	}
+
+	return missing
+}
+
+type glyphDrawer struct {
+	primary     *freetype.Context
+	fallback    *freetype.Context
+	useFallback map[string]bool
+}
+
+func newGlyphDrawer(c, fc *freetype.Context, w world.World) *glyphDrawer {
+	g := &glyphDrawer{
+		primary:     c,
+		fallback:    fc,
+		useFallback: make(map[string]bool),
+	}
+
+	for _, cell := range w.TerrainCellLookup {
+		if !hasGlyphs(mapFont, cell.Symbol) && hasGlyphs(fallbackFont, cell.Symbol) {
+			g.useFallback[cell.Symbol] = true
+		}
+	}
+
+	return g
 }
 
-func Image(w world.World, outputRoot string, includeLayers []int, terrain, seen, seenSolid, skipEmpty, cities bool) error {
+func (g *glyphDrawer) draw(s string, src image.Image, pt fixed.Point26_6) {
+	c := g.primary
+	if g.useFallback[s] {
+		c = g.fallback
+	}
+	c.SetSrc(src)
+	c.DrawString(s, pt)
+}
+
+// drawText draws s a glyph at a time, one cell apart, falling back per glyph
+// for text that isn't a known terrain symbol.
+func (g *glyphDrawer) drawText(s string, src image.Image, pt fixed.Point26_6) {
+	for _, r := range s {
+		glyph := string(r)
+		c := g.primary
+		if !hasGlyphs(mapFont, glyph) && hasGlyphs(fallbackFont, glyph) {
+			c = g.fallback
+		}
+		c.SetSrc(src)
+		c.DrawString(glyph, pt)
+		pt.X += g.primary.PointToFixed(cellWidth)
+	}
+}
+
+func Image(w world.World, outputRoot string, includeLayers []int, terrain, seen, seenSolid, skipEmpty, cities, notes, masked, dimExplored bool) error {
 	err := os.MkdirAll(outputRoot, os.ModePerm)
 	if err != nil {
 		return err
@@ -68,23 +181,57 @@ func Image(w world.World, outputRoot string, includeLayers []int, terrain, seen,
 	c.SetDst(fullImage)
 	c.SetHinting(font.HintingNone)
 
+	fc := freetype.NewContext()
+	fc.SetDPI(dpi)
+	fc.SetFont(fallbackFont)
+	fc.SetFontSize(size)
+	fc.SetClip(fullImage.Bounds())
+	fc.SetDst(fullImage)
+	fc.SetHinting(font.HintingNone)
+
+	if terrain {
+		for glyph, ids := range MissingGlyphs(w) {
+			log.WithFields(log.Fields{
+				"glyph":   glyph,
+				"terrain": ids,
+			}).Warn("unrenderable glyph")
+		}
+	}
+
+	g := newGlyphDrawer(c, fc, w)
+	u := make(uniforms)
+
 	for _, layerID := range includeLayers {
 		if terrain {
-			err := terrainToImage(e, fullImage, c, w, outputRoot, layerID, skipEmpty)
+			err := terrainToImage(e, fullImage, c, u, g, w, outputRoot, layerID, skipEmpty)
 			if err != nil {
 				return err
 			}
 		}
 
 		if seen {
-			err := seenToImage(e, fullImage, c, w, outputRoot, layerID, skipEmpty)
+			err := seenToImage(e, fullImage, c, u, w, outputRoot, layerID, skipEmpty)
 			if err != nil {
 				return err
 			}
 		}
 
 		if seenSolid {
-			err := seenToImageSolid(e, fullImage, c, w, outputRoot, layerID, skipEmpty)
+			err := seenToImageSolid(e, fullImage, c, u, w, outputRoot, layerID, skipEmpty)
+			if err != nil {
+				return err
+			}
+		}
+
+		if masked {
+			err := maskedToImage(e, fullImage, c, u, g, w, outputRoot, layerID, skipEmpty, dimExplored)
+			if err != nil {
+				return err
+			}
+		}
+
+		if notes {
+			err := notesToImage(e, fullImage, c, u, g, w, outputRoot, layerID, skipEmpty)
 			if err != nil {
 				return err
 			}
@@ -100,7 +247,7 @@ func Image(w world.World, outputRoot string, includeLayers []int, terrain, seen,
 	return nil
 }
 
-func terrainToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, w world.World, outputRoot string, layerID int, skipEmpty bool) error {
+func terrainToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, u uniforms, g *glyphDrawer, w world.World, outputRoot string, layerID int, skipEmpty bool) error {
 	l := w.TerrainLayers[layerID]
 
 	if l.Empty && skipEmpty {
@@ -113,21 +260,12 @@ func terrainToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context,
 	for _, r := range l.TerrainRows {
 		for _, k := range r.TerrainCellKeys {
 			cell := w.TerrainCellLookup[k]
-			bg, ok := colorCache[cell.ColorBG]
-			if !ok {
-				bg = image.NewUniform(cell.ColorBG)
-				colorCache[cell.ColorBG] = bg
-			}
+			bg := u.get(cell.ColorBG)
 
-			fg, ok := colorCache[cell.ColorFG]
-			if !ok {
-				fg = image.NewUniform(cell.ColorFG)
-				colorCache[cell.ColorFG] = fg
-			}
+			fg := u.get(cell.ColorFG)
 
 			draw.Draw(fullImage, image.Rect(int(pt.X>>6), int(pt.Y>>6), int(pt.X>>6)+cellOverprintWidth, int(pt.Y>>6)-cellHeight), bg, image.ZP, draw.Src)
-			c.SetSrc(fg)
-			c.DrawString(cell.Symbol, pt)
+			g.draw(cell.Symbol, fg, pt)
 			pt.X += c.PointToFixed(cellWidth)
 		}
 		pt.X = c.PointToFixed(0)
@@ -166,8 +304,8 @@ func write(filename string, e *png.Encoder, fullImage *image.RGBA) error {
 	return nil
 }
 
-func seenToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, w world.World, outputRoot string, layerID int, skipEmpty bool) error {
-	for name, layers := range w.SeenLayers {
+func seenToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, u uniforms, w world.World, outputRoot string, layerID int, skipEmpty bool) error {
+	for name, layers := range w.AllSeenLayers() {
 		l := layers[layerID]
 
 		if l.Empty && skipEmpty {
@@ -180,17 +318,9 @@ func seenToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, w w
 		for _, r := range l.SeenRows {
 			for _, k := range r.SeenCellKeys {
 				cell := w.SeenCellLookup[k]
-				bg, ok := colorCache[cell.ColorBG]
-				if !ok {
-					bg = image.NewUniform(cell.ColorBG)
-					colorCache[cell.ColorBG] = bg
-				}
+				bg := u.get(cell.ColorBG)
 
-				fg, ok := colorCache[cell.ColorFG]
-				if !ok {
-					fg = image.NewUniform(cell.ColorFG)
-					colorCache[cell.ColorFG] = fg
-				}
+				fg := u.get(cell.ColorFG)
 
 				draw.Draw(fullImage, image.Rect(int(pt.X>>6), int(pt.Y>>6), int(pt.X>>6)+cellOverprintWidth, int(pt.Y>>6)-cellHeight), bg, image.ZP, draw.Src)
 				c.SetSrc(fg)
@@ -201,6 +331,14 @@ func seenToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, w w
 			pt.Y += c.PointToFixed(size * spacing)
 		}
 
+		corruptToImage(fullImage, c, u, l, true)
+
+		for _, ch := range w.SeenCharacters(name) {
+			if ch.Layer == layerID {
+				characterToImage(fullImage, c, u, ch)
+			}
+		}
+
 		filename := filepath.Join(outputRoot, fmt.Sprintf("%v_visible_%v.png", name, layerID))
 		err := write(filename, e, fullImage)
 		if err != nil {
@@ -211,8 +349,8 @@ func seenToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, w w
 	return nil
 }
 
-func seenToImageSolid(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, w world.World, outputRoot string, layerID int, skipEmpty bool) error {
-	for name, layers := range w.SeenLayers {
+func seenToImageSolid(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, u uniforms, w world.World, outputRoot string, layerID int, skipEmpty bool) error {
+	for name, layers := range w.AllSeenLayers() {
 		l := layers[layerID]
 
 		if l.Empty && skipEmpty {
@@ -225,11 +363,7 @@ func seenToImageSolid(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context
 		for _, r := range l.SeenRows {
 			for _, k := range r.SeenCellKeys {
 				cell := w.SeenCellLookup[k]
-				bg, ok := colorCache[cell.ColorBG]
-				if !ok {
-					bg = image.NewUniform(cell.ColorBG)
-					colorCache[cell.ColorBG] = bg
-				}
+				bg := u.get(cell.ColorBG)
 
 				draw.Draw(fullImage, image.Rect(int(pt.X>>6), int(pt.Y>>6), int(pt.X>>6)+cellOverprintWidth, int(pt.Y>>6)-cellHeight), bg, image.ZP, draw.Src)
 				pt.X += c.PointToFixed(cellWidth)
@@ -238,6 +372,14 @@ func seenToImageSolid(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context
 			pt.Y += c.PointToFixed(size * spacing)
 		}
 
+		corruptToImage(fullImage, c, u, l, false)
+
+		for _, ch := range w.SeenCharacters(name) {
+			if ch.Layer == layerID {
+				characterToImage(fullImage, c, u, ch)
+			}
+		}
+
 		filename := filepath.Join(outputRoot, fmt.Sprintf("%v_visible_solid_%v.png", name, layerID))
 		err := write(filename, e, fullImage)
 		if err != nil {
@@ -248,6 +390,214 @@ func seenToImageSolid(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context
 	return nil
 }
 
+// dim darkens a color for cells that have been explored but aren't visible.
+func dim(c color.RGBA) color.RGBA {
+	return color.RGBA{c.R / 2, c.G / 2, c.B / 2, c.A}
+}
+
+// maskedToImage draws terrain through each character's fog of war: cells the
+// character hasn't seen are drawn as the unseen cell, and the rest show the
+// terrain, dimmed when dimExplored is set and the cell is explored but not
+// visible.
+func maskedToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, u uniforms, g *glyphDrawer, w world.World, outputRoot string, layerID int, skipEmpty, dimExplored bool) error {
+	tl := w.TerrainLayers[layerID]
+	unseen := w.SeenCellLookup[false]
+	explored := w.AllExploredLayers()
+
+	for name, layers := range w.AllSeenLayers() {
+		l := layers[layerID]
+
+		if l.Empty && skipEmpty {
+			continue
+		}
+
+		var el *world.SeenLayer
+		if dimExplored {
+			if ex, ok := explored[name]; ok && len(ex) > layerID {
+				el = &ex[layerID]
+			}
+		}
+
+		draw.Draw(fullImage, fullImage.Bounds(), image.Black, image.ZP, draw.Src)
+
+		pt := freetype.Pt(0, 0+int(c.PointToFixed(size)>>6))
+		for ri, r := range l.SeenRows {
+			for ci, visible := range r.SeenCellKeys {
+				symbol := unseen.Symbol
+				bgColor := unseen.ColorBG
+				fgColor := unseen.ColorFG
+
+				isExplored := el != nil && el.SeenRows[ri].SeenCellKeys[ci]
+				if visible || isExplored {
+					cell := w.TerrainCellLookup[tl.TerrainRows[ri].TerrainCellKeys[ci]]
+					symbol = cell.Symbol
+					bgColor = cell.ColorBG
+					fgColor = cell.ColorFG
+					if !visible {
+						bgColor = dim(bgColor)
+						fgColor = dim(fgColor)
+					}
+				}
+
+				draw.Draw(fullImage, image.Rect(int(pt.X>>6), int(pt.Y>>6), int(pt.X>>6)+cellOverprintWidth, int(pt.Y>>6)-cellHeight), u.get(bgColor), image.ZP, draw.Src)
+				g.draw(symbol, u.get(fgColor), pt)
+				pt.X += c.PointToFixed(cellWidth)
+			}
+			pt.X = c.PointToFixed(0)
+			pt.Y += c.PointToFixed(size * spacing)
+		}
+
+		corruptToImage(fullImage, c, u, l, true)
+
+		for _, ch := range w.SeenCharacters(name) {
+			if ch.Layer == layerID {
+				characterToImage(fullImage, c, u, ch)
+			}
+		}
+
+		filename := filepath.Join(outputRoot, fmt.Sprintf("%v_masked_%v.png", name, layerID))
+		err := write(filename, e, fullImage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// corruptToImage marks the chunks of a seen layer whose seen file couldn't be
+// decoded the way corrupt overmap chunks look, since their cells would
+// otherwise pass for unseen. Solid layers only get the background.
+func corruptToImage(fullImage *image.RGBA, c *freetype.Context, u uniforms, l world.SeenLayer, symbols bool) {
+	bg := u.get(world.CorruptCell.ColorBG)
+	fg := u.get(world.CorruptCell.ColorFG)
+
+	for _, chunk := range l.Corrupt {
+		for y := chunk[1] * 180; y < (chunk[1]+1)*180; y++ {
+			for x := chunk[0] * 180; x < (chunk[0]+1)*180; x++ {
+				pt := freetype.Pt(0, 0)
+				pt.X = c.PointToFixed(float64(x) * cellWidth)
+				pt.Y = c.PointToFixed(float64((y + 1) * cellHeight))
+
+				draw.Draw(fullImage, image.Rect(int(pt.X>>6), int(pt.Y>>6), int(pt.X>>6)+cellOverprintWidth, int(pt.Y>>6)-cellHeight), bg, image.ZP, draw.Src)
+				if symbols {
+					c.SetSrc(fg)
+					c.DrawString(world.CorruptCell.Symbol, pt)
+				}
+			}
+		}
+	}
+}
+
+var characterFG = color.RGBA{255, 255, 255, 255}
+var characterBG = color.RGBA{0, 0, 200, 255}
+
+// characterToImage marks the character's position with an @, the way the
+// game shows the player.
+func characterToImage(fullImage *image.RGBA, c *freetype.Context, u uniforms, ch world.Character) {
+	pt := freetype.Pt(0, 0)
+	pt.X = c.PointToFixed(float64(ch.X) * cellWidth)
+	pt.Y = c.PointToFixed(float64((ch.Y + 1) * cellHeight))
+
+	draw.Draw(fullImage, image.Rect(int(pt.X>>6), int(pt.Y>>6), int(pt.X>>6)+cellOverprintWidth, int(pt.Y>>6)-cellHeight), u.get(characterBG), image.ZP, draw.Src)
+	c.SetSrc(u.get(characterFG))
+	c.DrawString("@", pt)
+}
+
+var noteLabelBG = color.RGBA{0, 0, 0, 192}
+var noteDangerFill = color.RGBA{200, 0, 0, 64}
+var noteDangerEdge = color.RGBA{255, 0, 0, 255}
+
+// notesToImage draws each character's map notes on a transparent overlay: the
+// note symbol in its color, the text beside it, and a circle covering the
+// danger radius of dangerous notes.
+func notesToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, u uniforms, g *glyphDrawer, w world.World, outputRoot string, layerID int, skipEmpty bool) error {
+	for name, notes := range w.Notes {
+		layerNotes := make([]world.Note, 0)
+		for _, n := range notes {
+			if n.Layer == layerID {
+				layerNotes = append(layerNotes, n)
+			}
+		}
+
+		if len(layerNotes) == 0 && skipEmpty {
+			continue
+		}
+
+		draw.Draw(fullImage, fullImage.Bounds(), image.Transparent, image.ZP, draw.Src)
+
+		for _, n := range layerNotes {
+			if n.Dangerous && n.DangerRadius > 0 {
+				center := image.Pt(int((float64(n.X)+0.5)*cellWidth), n.Y*cellHeight+cellHeight/2)
+				radius := int((float64(n.DangerRadius) + 0.5) * cellWidth)
+				fill := &circle{center, radius, 0}
+				edge := &circle{center, radius, 2}
+				bounds := fill.Bounds().Intersect(fullImage.Bounds())
+				draw.DrawMask(fullImage, bounds, u.get(noteDangerFill), image.ZP, fill, bounds.Min, draw.Over)
+				draw.DrawMask(fullImage, bounds, u.get(noteDangerEdge), image.ZP, edge, bounds.Min, draw.Over)
+			}
+		}
+
+		for _, n := range layerNotes {
+			pt := freetype.Pt(0, 0)
+			pt.X = c.PointToFixed(float64(n.X) * cellWidth)
+			pt.Y = c.PointToFixed(float64((n.Y + 1) * cellHeight))
+
+			draw.Draw(fullImage, image.Rect(int(pt.X>>6), int(pt.Y>>6), int(pt.X>>6)+cellOverprintWidth, int(pt.Y>>6)-cellHeight), image.Black, image.ZP, draw.Src)
+			g.drawText(n.Symbol, u.get(n.Color), pt)
+
+			if n.Text == "" {
+				continue
+			}
+
+			pt.X += c.PointToFixed(cellWidth)
+			labelWidth := int(float64(utf8.RuneCountInString(n.Text)) * cellWidth)
+			draw.Draw(fullImage, image.Rect(int(pt.X>>6), int(pt.Y>>6)+2, int(pt.X>>6)+labelWidth, int(pt.Y>>6)-cellHeight), u.get(noteLabelBG), image.ZP, draw.Over)
+			g.drawText(n.Text, u.get(n.Color), pt)
+		}
+
+		filename := filepath.Join(outputRoot, fmt.Sprintf("%v_notes_%v.png", name, layerID))
+		err := write(filename, e, fullImage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// circle is a mask for a filled circle, or for a ring of the given width when
+// width is non-zero.
+type circle struct {
+	p     image.Point
+	r     int
+	width int
+}
+
+func (c *circle) ColorModel() color.Model {
+	return color.AlphaModel
+}
+
+func (c *circle) Bounds() image.Rectangle {
+	return image.Rect(c.p.X-c.r, c.p.Y-c.r, c.p.X+c.r, c.p.Y+c.r)
+}
+
+func (c *circle) At(x, y int) color.Color {
+	xx, yy := float64(x-c.p.X)+0.5, float64(y-c.p.Y)+0.5
+	d := xx*xx + yy*yy
+	outer := float64(c.r * c.r)
+	if d > outer {
+		return color.Alpha{0}
+	}
+	if c.width > 0 {
+		inner := float64((c.r - c.width) * (c.r - c.width))
+		if d < inner {
+			return color.Alpha{0}
+		}
+	}
+	return color.Alpha{255}
+}
+
 func citiesToImage(e *png.Encoder, fullImage *image.RGBA, c *freetype.Context, w world.World, outputRoot string) error {
 	draw.Draw(fullImage, fullImage.Bounds(), image.Transparent, image.ZP, draw.Src)
 
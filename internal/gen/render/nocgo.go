@@ -0,0 +1,6 @@
+//go:build !cgo
+// +build !cgo
+
+package render
+
+const cgoEnabled = false
@@ -0,0 +1,277 @@
+package render
+
+import (
+	"strings"
+)
+
+// region is a 4-connected group of cells that share a key, covered by
+// rectangles of cells. Each rectangle is x1, y1, x2, y2 with exclusive ends.
+// With outlines, polygons holds the region's outline in cell corner
+// coordinates, as polygons made of an outer ring followed by its holes.
+type region struct {
+	key      string
+	cells    int
+	rects    [][4]int
+	polygons [][][][2]int
+}
+
+// regions groups a width by height grid into regions of equal keys. Cells
+// with an empty key are left out.
+func regions(width, height int, key func(x, y int) string, outlines bool) []region {
+	labels := make([]int32, width*height)
+	found := make([]region, 0)
+	queue := make([]int, 0)
+
+	for start := range labels {
+		if labels[start] != 0 {
+			continue
+		}
+		k := key(start%width, start/width)
+		if k == "" {
+			continue
+		}
+
+		found = append(found, region{key: k})
+		label := int32(len(found))
+		labels[start] = label
+		queue = append(queue[:0], start)
+
+		for len(queue) > 0 {
+			i := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			found[label-1].cells++
+
+			x, y := i%width, i/width
+			for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				if n[0] < 0 || n[0] >= width || n[1] < 0 || n[1] >= height {
+					continue
+				}
+				ni := n[1]*width + n[0]
+				if labels[ni] == 0 && key(n[0], n[1]) == k {
+					labels[ni] = label
+					queue = append(queue, ni)
+				}
+			}
+		}
+	}
+
+	// Runs of a row are stacked onto the rectangle above them when they span
+	// the same columns, which keeps the rectangle count down for blocky
+	// terrain like fields and forests.
+	open := make([]map[[2]int]int, len(found))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			label := labels[y*width+x]
+			if label == 0 {
+				continue
+			}
+
+			x1 := x
+			for x+1 < width && labels[y*width+x+1] == label {
+				x++
+			}
+			span := [2]int{x1, x + 1}
+
+			r := &found[label-1]
+			if open[label-1] == nil {
+				open[label-1] = make(map[[2]int]int)
+			}
+			if ri, ok := open[label-1][span]; ok && r.rects[ri][3] == y {
+				r.rects[ri][3] = y + 1
+				continue
+			}
+			open[label-1][span] = len(r.rects)
+			r.rects = append(r.rects, [4]int{span[0], y, span[1], y + 1})
+		}
+	}
+
+	if outlines {
+		traceOutlines(found, labels, width, height)
+	}
+
+	return found
+}
+
+type gridEdge struct {
+	from, to [2]int
+}
+
+// traceOutlines walks the cell edges between each region and everything else
+// into rings. Edges run clockwise on screen around the cells they bound, so
+// outer rings come out clockwise and holes counterclockwise. Where two cells
+// of a region only touch at a corner, the walk turns across to the other
+// cell, so a hole that touches the outside at that corner becomes a hole
+// touching the outer ring rather than a ring touching itself.
+func traceOutlines(found []region, labels []int32, width, height int) {
+	in := func(x, y int, label int32) bool {
+		return x >= 0 && x < width && y >= 0 && y < height && labels[y*width+x] == label
+	}
+
+	edges := make([]map[[2]int][]gridEdge, len(found))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			label := labels[y*width+x]
+			if label == 0 {
+				continue
+			}
+			if edges[label-1] == nil {
+				edges[label-1] = make(map[[2]int][]gridEdge)
+			}
+			e := edges[label-1]
+			add := func(from, to [2]int) {
+				e[from] = append(e[from], gridEdge{from, to})
+			}
+
+			if !in(x, y-1, label) {
+				add([2]int{x, y}, [2]int{x + 1, y})
+			}
+			if !in(x+1, y, label) {
+				add([2]int{x + 1, y}, [2]int{x + 1, y + 1})
+			}
+			if !in(x, y+1, label) {
+				add([2]int{x + 1, y + 1}, [2]int{x, y + 1})
+			}
+			if !in(x-1, y, label) {
+				add([2]int{x, y + 1}, [2]int{x, y})
+			}
+		}
+	}
+
+	for i := range found {
+		found[i].polygons = ringsToPolygons(traceRings(edges[i]))
+	}
+}
+
+func traceRings(edges map[[2]int][]gridEdge) [][][2]int {
+	rings := make([][][2]int, 0)
+
+	take := func(at [2]int, pick int) gridEdge {
+		out := edges[at]
+		e := out[pick]
+		out = append(out[:pick], out[pick+1:]...)
+		if len(out) == 0 {
+			delete(edges, at)
+		} else {
+			edges[at] = out
+		}
+		return e
+	}
+
+	for len(edges) > 0 {
+		var start [2]int
+		for v := range edges {
+			start = v
+			break
+		}
+
+		// The walk ends when it would take the first edge again, which at a
+		// corner-touching vertex may only happen on a later visit.
+		first := take(start, 0)
+		firstDir := [2]int{first.to[0] - start[0], first.to[1] - start[1]}
+		dir := firstDir
+		ring := [][2]int{start, first.to}
+		at := first.to
+
+		for {
+			left := [2]int{dir[1], -dir[0]}
+			out := edges[at]
+
+			if at == start && (len(out) == 0 || left == firstDir) {
+				break
+			}
+
+			pick := 0
+			for oi, o := range out {
+				if o.to[0]-o.from[0] == left[0] && o.to[1]-o.from[1] == left[1] {
+					pick = oi
+					break
+				}
+			}
+
+			e := take(at, pick)
+			next := [2]int{e.to[0] - e.from[0], e.to[1] - e.from[1]}
+			if next == dir {
+				ring[len(ring)-1] = e.to
+			} else {
+				ring = append(ring, e.to)
+			}
+			dir = next
+			at = e.to
+		}
+
+		// The start is only a corner when the walk turned there.
+		if firstDir == dir {
+			ring = ring[1 : len(ring)-1]
+			ring = append(ring, ring[0])
+		}
+
+		rings = append(rings, ring)
+	}
+
+	return rings
+}
+
+// ringsToPolygons pairs each hole with the outer ring around it. Outer rings
+// have a positive signed area in screen coordinates.
+func ringsToPolygons(rings [][][2]int) [][][][2]int {
+	polygons := make([][][][2]int, 0)
+	holes := make([][][2]int, 0)
+	for _, r := range rings {
+		if signedArea(r) > 0 {
+			polygons = append(polygons, [][][2]int{r})
+		} else {
+			holes = append(holes, r)
+		}
+	}
+
+	for _, h := range holes {
+		// The middle of an edge is never on another ring, so it is strictly
+		// inside or outside every outer ring.
+		mx := float64(h[0][0]+h[1][0]) / 2
+		my := float64(h[0][1]+h[1][1]) / 2
+		for pi, p := range polygons {
+			if len(polygons) == 1 || ringContains(p[0], mx, my) {
+				polygons[pi] = append(polygons[pi], h)
+				break
+			}
+		}
+	}
+
+	return polygons
+}
+
+func signedArea(ring [][2]int) int {
+	a := 0
+	for i := 0; i+1 < len(ring); i++ {
+		a += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return a / 2
+}
+
+func ringContains(ring [][2]int, x, y float64) bool {
+	inside := false
+	for i := 0; i+1 < len(ring); i++ {
+		x1, y1 := float64(ring[i][0]), float64(ring[i][1])
+		x2, y2 := float64(ring[i+1][0]), float64(ring[i+1][1])
+		if (y1 > y) != (y2 > y) && x < x1+(y-y1)*(x2-x1)/(y2-y1) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// wkt returns the region's rectangles as a geometry collection. The
+// rectangles share edges, so it's left to PostGIS to union them into a valid
+// multipolygon.
+func (r region) wkt(t transform) string {
+	var b strings.Builder
+	b.WriteString("GEOMETRYCOLLECTION(")
+	for i, rect := range r.rects {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(t.rectWKT(rect[0], rect[1], rect[2], rect[3]))
+	}
+	b.WriteString(")")
+	return t.ewkt(b.String())
+}
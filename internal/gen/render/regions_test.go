@@ -0,0 +1,175 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTraceOutlines(t *testing.T) {
+	type outline struct {
+		key   string
+		cells int
+		// points is the number of points of each ring, outer ring first,
+		// counting the closing point
+		points [][]int
+	}
+
+	tests := []struct {
+		name     string
+		grid     []string
+		outlines []outline
+	}{
+		{
+			name:     "single cell",
+			grid:     []string{"a"},
+			outlines: []outline{{"a", 1, [][]int{{5}}}},
+		},
+		{
+			name:     "rectangle",
+			grid:     []string{"aaa", "aaa"},
+			outlines: []outline{{"a", 6, [][]int{{5}}}},
+		},
+		{
+			name:     "l shape",
+			grid:     []string{"a.", "aa"},
+			outlines: []outline{{"a", 3, [][]int{{7}}}},
+		},
+		{
+			name:     "hole",
+			grid:     []string{"aaa", "a.a", "aaa"},
+			outlines: []outline{{"a", 8, [][]int{{5, 5}}}},
+		},
+		{
+			name: "hole touching the outside at a corner",
+			grid: []string{"aa.", "a.a", "aaa"},
+			outlines: []outline{
+				{"a", 7, [][]int{{7, 5}}},
+			},
+		},
+		{
+			name: "cells touching at a corner are separate regions",
+			grid: []string{"a.", ".a"},
+			outlines: []outline{
+				{"a", 1, [][]int{{5}}},
+				{"a", 1, [][]int{{5}}},
+			},
+		},
+		{
+			name: "keys",
+			grid: []string{"ab", "ab"},
+			outlines: []outline{
+				{"a", 2, [][]int{{5}}},
+				{"b", 2, [][]int{{5}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := func(x, y int) string {
+				if k := tt.grid[y][x]; k != '.' {
+					return string(k)
+				}
+				return ""
+			}
+
+			found := regions(len(tt.grid[0]), len(tt.grid), key, true)
+			if len(found) != len(tt.outlines) {
+				t.Fatalf("got %v regions, want %v", len(found), len(tt.outlines))
+			}
+
+			for i, r := range found {
+				want := tt.outlines[i]
+				if r.key != want.key || r.cells != want.cells {
+					t.Errorf("region %v: got key %q with %v cells, want %q with %v", i, r.key, r.cells, want.key, want.cells)
+				}
+
+				area := 0
+				points := make([][]int, 0)
+				for _, p := range r.polygons {
+					counts := make([]int, 0)
+					for ri, ring := range p {
+						if ring[0] != ring[len(ring)-1] {
+							t.Errorf("region %v: ring %v isn't closed: %v", i, ri, ring)
+						}
+						a := signedArea(ring)
+						if ri == 0 && a <= 0 || ri > 0 && a >= 0 {
+							t.Errorf("region %v: ring %v winds the wrong way: %v", i, ri, ring)
+						}
+						area += a
+						counts = append(counts, len(ring))
+					}
+					points = append(points, counts)
+				}
+
+				if area != r.cells {
+					t.Errorf("region %v: outline covers %v cells, want %v", i, area, r.cells)
+				}
+				if !reflect.DeepEqual(points, want.points) {
+					t.Errorf("region %v: got ring points %v, want %v", i, points, want.points)
+				}
+			}
+		})
+	}
+}
+
+func TestRingsToPolygons(t *testing.T) {
+	square := func(x1, y1, x2, y2 int) [][2]int {
+		return [][2]int{{x1, y1}, {x2, y1}, {x2, y2}, {x1, y2}, {x1, y1}}
+	}
+	hole := func(x1, y1, x2, y2 int) [][2]int {
+		return [][2]int{{x1, y1}, {x1, y2}, {x2, y2}, {x2, y1}, {x1, y1}}
+	}
+
+	tests := []struct {
+		name     string
+		rings    [][][2]int
+		polygons [][][][2]int
+	}{
+		{
+			name:     "outer ring",
+			rings:    [][][2]int{square(0, 0, 2, 2)},
+			polygons: [][][][2]int{{square(0, 0, 2, 2)}},
+		},
+		{
+			name:     "hole before its outer ring",
+			rings:    [][][2]int{hole(1, 1, 2, 2), square(0, 0, 3, 3)},
+			polygons: [][][][2]int{{square(0, 0, 3, 3), hole(1, 1, 2, 2)}},
+		},
+		{
+			name: "holes go to the ring around them",
+			rings: [][][2]int{
+				hole(11, 1, 12, 2),
+				square(0, 0, 4, 4),
+				square(10, 0, 14, 4),
+				hole(1, 1, 2, 2),
+				hole(2, 2, 3, 3),
+			},
+			polygons: [][][][2]int{
+				{square(0, 0, 4, 4), hole(1, 1, 2, 2), hole(2, 2, 3, 3)},
+				{square(10, 0, 14, 4), hole(11, 1, 12, 2)},
+			},
+		},
+		{
+			name: "polygon inside a hole",
+			rings: [][][2]int{
+				square(0, 0, 5, 5),
+				hole(1, 1, 4, 4),
+				square(2, 2, 3, 3),
+			},
+			polygons: [][][][2]int{
+				{square(0, 0, 5, 5), hole(1, 1, 4, 4)},
+				{square(2, 2, 3, 3)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polygons := ringsToPolygons(tt.rings)
+			if !reflect.DeepEqual(polygons, tt.polygons) {
+				t.Errorf("got %v, want %v", polygons, tt.polygons)
+			}
+		})
+	}
+}
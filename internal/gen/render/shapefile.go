@@ -0,0 +1,258 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	shapePoint   = 1
+	shapePolygon = 5
+)
+
+// writeShapefile writes an ESRI Shapefile as base.shp, .shx, .dbf and .cpg,
+// plus a .prj in the metric CRS. Shapefile wants outer rings clockwise, the
+// reverse of vector layers.
+func writeShapefile(l vectorLayer, base string, crs CRS) error {
+	shapeType := int32(shapePolygon)
+	if l.points {
+		shapeType = shapePoint
+	}
+
+	records := make([][]byte, len(l.features))
+	bbox := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	extend := func(b *[4]float64, p [2]float64) {
+		b[0] = math.Min(b[0], p[0])
+		b[1] = math.Min(b[1], p[1])
+		b[2] = math.Max(b[2], p[0])
+		b[3] = math.Max(b[3], p[1])
+	}
+
+	for fi, f := range l.features {
+		var b bytes.Buffer
+		binary.Write(&b, binary.LittleEndian, shapeType)
+
+		if l.points {
+			binary.Write(&b, binary.LittleEndian, f.point)
+			extend(&bbox, f.point)
+		} else {
+			parts := make([]int32, 0)
+			points := make([][2]float64, 0)
+			fb := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+			for _, p := range f.polygons {
+				for _, ring := range p {
+					parts = append(parts, int32(len(points)))
+					for vi := len(ring) - 1; vi >= 0; vi-- {
+						points = append(points, ring[vi])
+						extend(&fb, ring[vi])
+					}
+				}
+			}
+			extend(&bbox, [2]float64{fb[0], fb[1]})
+			extend(&bbox, [2]float64{fb[2], fb[3]})
+
+			binary.Write(&b, binary.LittleEndian, fb)
+			binary.Write(&b, binary.LittleEndian, int32(len(parts)))
+			binary.Write(&b, binary.LittleEndian, int32(len(points)))
+			binary.Write(&b, binary.LittleEndian, parts)
+			binary.Write(&b, binary.LittleEndian, points)
+		}
+		records[fi] = b.Bytes()
+	}
+
+	if len(records) == 0 {
+		bbox = [4]float64{}
+	}
+
+	shpLength := 100
+	for _, r := range records {
+		shpLength += 8 + len(r)
+	}
+	shxLength := 100 + 8*len(records)
+
+	shp, err := os.Create(base + ".shp")
+	if err != nil {
+		return err
+	}
+	defer shp.Close()
+	shpw := bufio.NewWriter(shp)
+
+	shx, err := os.Create(base + ".shx")
+	if err != nil {
+		return err
+	}
+	defer shx.Close()
+	shxw := bufio.NewWriter(shx)
+
+	writeShapeHeader(shpw, shpLength, shapeType, bbox)
+	writeShapeHeader(shxw, shxLength, shapeType, bbox)
+
+	offset := 100
+	for ri, r := range records {
+		binary.Write(shpw, binary.BigEndian, int32(ri+1))
+		binary.Write(shpw, binary.BigEndian, int32(len(r)/2))
+		shpw.Write(r)
+
+		binary.Write(shxw, binary.BigEndian, int32(offset/2))
+		binary.Write(shxw, binary.BigEndian, int32(len(r)/2))
+		offset += 8 + len(r)
+	}
+
+	err = shpw.Flush()
+	if err != nil {
+		return err
+	}
+	err = shxw.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = writeDBF(l, base+".dbf")
+	if err != nil {
+		return err
+	}
+
+	if crs.Metric {
+		err = ioutil.WriteFile(base+".prj", []byte(localMetricWKT), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(base+".cpg", []byte("UTF-8"), 0644)
+}
+
+// writeShapeHeader writes the header shared by .shp and .shx. Lengths are in
+// bytes here and 16-bit words in the file.
+func writeShapeHeader(w *bufio.Writer, length int, shapeType int32, bbox [4]float64) {
+	binary.Write(w, binary.BigEndian, int32(9994))
+	binary.Write(w, binary.BigEndian, [5]int32{})
+	binary.Write(w, binary.BigEndian, int32(length/2))
+	binary.Write(w, binary.LittleEndian, int32(1000))
+	binary.Write(w, binary.LittleEndian, shapeType)
+	binary.Write(w, binary.LittleEndian, bbox)
+	binary.Write(w, binary.LittleEndian, [4]float64{})
+}
+
+const dbfMaxWidth = 254
+
+// writeDBF writes the attribute table as dBase III, with strings sized to the
+// longest value.
+func writeDBF(l vectorLayer, file string) error {
+	type column struct {
+		name  string
+		kind  byte
+		width int
+	}
+
+	columns := make([]column, len(l.fields))
+	for fi, field := range l.fields {
+		c := column{name: field.name}
+		if len(c.name) > 10 {
+			c.name = c.name[:10]
+		}
+		switch field.kind {
+		case intField:
+			c.kind = 'N'
+			c.width = 11
+		case boolField:
+			c.kind = 'L'
+			c.width = 1
+		default:
+			c.kind = 'C'
+			c.width = 1
+			for _, f := range l.features {
+				if n := len(dbfString(f.values[fi])); n > c.width {
+					c.width = n
+				}
+			}
+		}
+		columns[fi] = c
+	}
+
+	recordLength := 1
+	for _, c := range columns {
+		recordLength += c.width
+	}
+	headerLength := 32 + 32*len(columns) + 1
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	now := time.Now()
+	w.Write([]byte{0x03, byte(now.Year() - 1900), byte(now.Month()), byte(now.Day())})
+	binary.Write(w, binary.LittleEndian, uint32(len(l.features)))
+	binary.Write(w, binary.LittleEndian, uint16(headerLength))
+	binary.Write(w, binary.LittleEndian, uint16(recordLength))
+	w.Write(make([]byte, 20))
+
+	for _, c := range columns {
+		name := make([]byte, 11)
+		copy(name, c.name)
+		w.Write(name)
+		w.WriteByte(c.kind)
+		w.Write(make([]byte, 4))
+		w.WriteByte(byte(c.width))
+		w.WriteByte(0)
+		w.Write(make([]byte, 14))
+	}
+	w.WriteByte(0x0d)
+
+	for _, feature := range l.features {
+		w.WriteByte(' ')
+		for ci, c := range columns {
+			var value string
+			switch c.kind {
+			case 'N':
+				value = fmt.Sprintf("%*v", c.width, feature.values[ci])
+			case 'L':
+				value = "F"
+				if b, ok := feature.values[ci].(bool); ok && b {
+					value = "T"
+				}
+			default:
+				value = dbfString(feature.values[ci])
+				value += string(bytes.Repeat([]byte{' '}, c.width-len(value)))
+			}
+			w.WriteString(value)
+		}
+	}
+	w.WriteByte(0x1a)
+
+	return w.Flush()
+}
+
+// dbfString cuts a value down to the widest a character field can be without
+// splitting a UTF-8 sequence.
+func dbfString(v interface{}) string {
+	var s string
+	switch t := v.(type) {
+	case string:
+		s = t
+	case int:
+		s = strconv.Itoa(t)
+	default:
+		s = fmt.Sprint(t)
+	}
+
+	if len(s) <= dbfMaxWidth {
+		return s
+	}
+	s = s[:dbfMaxWidth]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -67,16 +67,36 @@ func terrainToText(w world.World, outputRoot string, layerID int, skipEmpty bool
 }
 
 func seenToText(w world.World, outputRoot string, layerID int, skipEmpty bool) error {
-	for name, layers := range w.SeenLayers {
+	for name, layers := range w.AllSeenLayers() {
 		l := layers[layerID]
 
 		if l.Empty && skipEmpty {
 			continue
 		}
 
+		marked := make(map[[2]int]bool)
+		for _, ch := range w.SeenCharacters(name) {
+			if ch.Layer == layerID {
+				marked[[2]int{ch.X, ch.Y}] = true
+			}
+		}
+
+		corrupt := make(map[[2]int]bool)
+		for _, chunk := range l.Corrupt {
+			corrupt[chunk] = true
+		}
+
 		var b strings.Builder
-		for _, r := range l.SeenRows {
-			for _, k := range r.SeenCellKeys {
+		for ri, r := range l.SeenRows {
+			for ci, k := range r.SeenCellKeys {
+				if marked[[2]int{ci, ri}] {
+					b.WriteString("@")
+					continue
+				}
+				if corrupt[[2]int{ci / 180, ri / 180}] {
+					b.WriteString(world.CorruptCell.Symbol)
+					continue
+				}
 				cell := w.SeenCellLookup[k]
 				b.WriteString(cell.Symbol)
 
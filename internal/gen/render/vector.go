@@ -0,0 +1,239 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ralreegorganon/cddamap/internal/gen/save"
+	"github.com/ralreegorganon/cddamap/internal/gen/world"
+)
+
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	intField
+	boolField
+)
+
+type vectorField struct {
+	name string
+	kind fieldKind
+}
+
+// vectorFeature is either a multipolygon or a point, with a value per field
+// of its layer.
+type vectorFeature struct {
+	polygons [][][][2]float64
+	point    [2]float64
+	values   []interface{}
+}
+
+// vectorLayer is a table of features that the file exporters write out.
+// Outer rings are counterclockwise and holes clockwise, as GeoJSON prefers.
+type vectorLayer struct {
+	name     string
+	points   bool
+	fields   []vectorField
+	features []vectorFeature
+}
+
+// terrainKeys returns region keys for a terrain layer, by terrain ID without
+// empty rock, open air and unexplored chunks, and by overmap special.
+func terrainKeys(w world.World, l world.TerrainLayer) (func(x, y int) string, func(x, y int) string) {
+	emptyRockHash := save.HashTerrainID("empty_rock")
+	openAirHash := save.HashTerrainID("open_air")
+	blankHash := save.HashTerrainID("")
+
+	terrain := func(x, y int) string {
+		k := l.TerrainRows[y].TerrainCellKeys[x]
+		if k == emptyRockHash || k == openAirHash || k == blankHash {
+			return ""
+		}
+		return w.TerrainCellLookup[k].ID
+	}
+	special := func(x, y int) string {
+		return w.TerrainCellLookup[l.TerrainRows[y].TerrainCellKeys[x]].Special
+	}
+	return terrain, special
+}
+
+func regionPolygons(r region, t transform) [][][][2]float64 {
+	polygons := make([][][][2]float64, len(r.polygons))
+	for pi, p := range r.polygons {
+		polygons[pi] = make([][][2]float64, len(p))
+		for ri, ring := range p {
+			polygons[pi][ri] = make([][2]float64, len(ring))
+			for vi, v := range ring {
+				pv := t.apply([2]float64{float64(v[0]) * cellWidth, float64(v[1]) * float64(cellHeight)})
+				if t.flips() {
+					polygons[pi][ri][len(ring)-1-vi] = pv
+				} else {
+					polygons[pi][ri][vi] = pv
+				}
+			}
+		}
+	}
+	return polygons
+}
+
+func cellCenter(x, y int) [2]float64 {
+	return [2]float64{float64(x)*cellWidth + cellWidth/2, float64(y)*float64(cellHeight) + float64(cellHeight)/2}
+}
+
+func vectorLayers(w world.World, t transform, includeLayers []int, terrain, seen, cities, notes bool) []vectorLayer {
+	layers := make([]vectorLayer, 0)
+
+	characterIDs := make([]string, 0, len(w.SeenLayers))
+	for id := range w.SeenLayers {
+		characterIDs = append(characterIDs, id)
+	}
+	sort.Strings(characterIDs)
+
+	characterName := func(id string) string {
+		if c, ok := w.Characters[id]; ok && c.Name != "" {
+			return c.Name
+		}
+		return id
+	}
+
+	for _, i := range includeLayers {
+		if terrain {
+			l := w.TerrainLayers[i]
+			height := len(l.TerrainRows)
+			width := len(l.TerrainRows[0].TerrainCellKeys)
+			terrainKey, specialKey := terrainKeys(w, l)
+
+			names := make(map[string]string)
+			for _, c := range w.TerrainCellLookup {
+				names[c.ID] = c.Name
+			}
+
+			tl := vectorLayer{
+				name:   fmt.Sprintf("terrain_%v", i),
+				fields: []vectorField{{"id", stringField}, {"name", stringField}, {"cells", intField}},
+			}
+			for _, r := range regions(width, height, terrainKey, true) {
+				tl.features = append(tl.features, vectorFeature{
+					polygons: regionPolygons(r, t),
+					values:   []interface{}{r.key, names[r.key], r.cells},
+				})
+			}
+			layers = append(layers, tl)
+
+			bl := vectorLayer{
+				name:   fmt.Sprintf("buildings_%v", i),
+				fields: []vectorField{{"special", stringField}, {"cells", intField}},
+			}
+			for _, r := range regions(width, height, specialKey, true) {
+				bl.features = append(bl.features, vectorFeature{
+					polygons: regionPolygons(r, t),
+					values:   []interface{}{r.key, r.cells},
+				})
+			}
+			layers = append(layers, bl)
+		}
+
+		if seen {
+			sl := vectorLayer{
+				name:   fmt.Sprintf("seen_%v", i),
+				fields: []vectorField{{"character", stringField}, {"name", stringField}, {"cells", intField}},
+			}
+			for _, id := range characterIDs {
+				l := w.SeenLayers[id][i]
+				if len(l.SeenRows) == 0 {
+					continue
+				}
+				seenKey := func(x, y int) string {
+					if l.SeenRows[y].SeenCellKeys[x] {
+						return "seen"
+					}
+					return ""
+				}
+				for _, r := range regions(len(l.SeenRows[0].SeenCellKeys), len(l.SeenRows), seenKey, true) {
+					sl.features = append(sl.features, vectorFeature{
+						polygons: regionPolygons(r, t),
+						values:   []interface{}{id, characterName(id), r.cells},
+					})
+				}
+			}
+			layers = append(layers, sl)
+		}
+	}
+
+	if cities {
+		cl := vectorLayer{
+			name:   "cities",
+			points: true,
+			fields: []vectorField{{"name", stringField}, {"size", intField}},
+		}
+		for _, c := range w.CityLayer.Cities {
+			cl.features = append(cl.features, vectorFeature{
+				point:  t.apply(cellCenter(c.X, c.Y)),
+				values: []interface{}{c.Name, c.Size},
+			})
+		}
+		layers = append(layers, cl)
+	}
+
+	if notes {
+		included := make(map[int]bool)
+		for _, i := range includeLayers {
+			included[i] = true
+		}
+
+		nl := vectorLayer{
+			name:   "notes",
+			points: true,
+			fields: []vectorField{{"character", stringField}, {"z", intField}, {"symbol", stringField}, {"color", stringField}, {"text", stringField}, {"dangerous", boolField}, {"radius", intField}},
+		}
+		noteIDs := make([]string, 0, len(w.Notes))
+		for id := range w.Notes {
+			noteIDs = append(noteIDs, id)
+		}
+		sort.Strings(noteIDs)
+
+		for _, id := range noteIDs {
+			for _, n := range w.Notes[id] {
+				if !included[n.Layer] {
+					continue
+				}
+				nl.features = append(nl.features, vectorFeature{
+					point:  t.apply(cellCenter(n.X, n.Y)),
+					values: []interface{}{id, n.Layer, n.Symbol, n.ColorName, n.Text, n.Dangerous, n.DangerRadius},
+				})
+			}
+		}
+		layers = append(layers, nl)
+	}
+
+	chl := vectorLayer{
+		name:   "characters",
+		points: true,
+		fields: []vectorField{{"character", stringField}, {"name", stringField}, {"z", intField}, {"turn", intField}},
+	}
+	ids := make([]string, 0, len(w.Characters))
+	for id := range w.Characters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		c := w.Characters[id]
+		if !c.Located {
+			continue
+		}
+		chl.features = append(chl.features, vectorFeature{
+			point:  t.apply(cellCenter(c.X, c.Y)),
+			values: []interface{}{id, c.Name, c.Layer, c.Turn},
+		})
+	}
+	layers = append(layers, chl)
+
+	nonEmpty := layers[:0]
+	for _, l := range layers {
+		if len(l.features) > 0 {
+			nonEmpty = append(nonEmpty, l)
+		}
+	}
+	return nonEmpty
+}
@@ -0,0 +1,344 @@
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxExamples = 5
+
+var sampleInterval = 250 * time.Millisecond
+
+type Location struct {
+	ChunkX int `json:"chunkX"`
+	ChunkY int `json:"chunkY"`
+	Layer  int `json:"layer"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+}
+
+type MissingTerrain struct {
+	Count    int        `json:"count"`
+	Examples []Location `json:"examples"`
+}
+
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type SkippedEntry struct {
+	Path   string `json:"path"`
+	Entry  int    `json:"entry"`
+	Reason string `json:"reason"`
+}
+
+type DecodeError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// CorruptChunk is a chunk file skipped in lenient mode. Character is set for
+// the seen chunks of a character and empty for overmap chunks.
+type CorruptChunk struct {
+	Character string `json:"character,omitempty"`
+	Path      string `json:"path"`
+	ChunkX    int    `json:"chunkX"`
+	ChunkY    int    `json:"chunkY"`
+}
+
+type UnsupportedVersion struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+type Stage struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+type Memory struct {
+	PeakHeapInuseBytes uint64 `json:"peakHeapInuseBytes"`
+	PeakSysBytes       uint64 `json:"peakSysBytes"`
+}
+
+// Report collects the diagnostics of a single generation run. All methods are
+// safe to call on a nil *Report, which discards everything.
+type Report struct {
+	mu                  sync.Mutex
+	World               string                     `json:"world"`
+	Started             time.Time                  `json:"started"`
+	Finished            time.Time                  `json:"finished"`
+	Error               string                     `json:"error,omitempty"`
+	MissingTerrain      map[string]*MissingTerrain `json:"missingTerrain"`
+	UnknownColors       map[string][]string        `json:"unknownColors"`
+	UnknownSymbols      map[string][]string        `json:"unknownSymbols"`
+	MissingSymbols      []string                   `json:"missingSymbols"`
+	SkippedFiles        []SkippedFile              `json:"skippedFiles"`
+	SkippedEntries      []SkippedEntry             `json:"skippedEntries"`
+	UnsupportedVersions []UnsupportedVersion       `json:"unsupportedVersions"`
+	DecodeErrors        []DecodeError              `json:"decodeErrors"`
+	CorruptChunks       []CorruptChunk             `json:"corruptChunks"`
+	Stages              []Stage                    `json:"stages"`
+	Memory              Memory                     `json:"memory"`
+	stop                chan struct{}
+	done                chan struct{}
+}
+
+// New starts a report for the named world, sampling memory usage until Close
+// is called.
+func New(world string) *Report {
+	r := &Report{
+		World:               world,
+		Started:             time.Now(),
+		MissingTerrain:      make(map[string]*MissingTerrain),
+		UnknownColors:       make(map[string][]string),
+		UnknownSymbols:      make(map[string][]string),
+		MissingSymbols:      make([]string, 0),
+		SkippedFiles:        make([]SkippedFile, 0),
+		SkippedEntries:      make([]SkippedEntry, 0),
+		UnsupportedVersions: make([]UnsupportedVersion, 0),
+		DecodeErrors:        make([]DecodeError, 0),
+		CorruptChunks:       make([]CorruptChunk, 0),
+		Stages:              make([]Stage, 0),
+		stop:                make(chan struct{}),
+		done:                make(chan struct{}),
+	}
+
+	go r.sample()
+
+	return r
+}
+
+func (r *Report) sample() {
+	t := time.NewTicker(sampleInterval)
+	defer t.Stop()
+	defer close(r.done)
+
+	for {
+		r.sampleMemory()
+		select {
+		case <-t.C:
+		case <-r.stop:
+			r.sampleMemory()
+			return
+		}
+	}
+}
+
+func (r *Report) sampleMemory() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m.HeapInuse > r.Memory.PeakHeapInuseBytes {
+		r.Memory.PeakHeapInuseBytes = m.HeapInuse
+	}
+	if m.Sys > r.Memory.PeakSysBytes {
+		r.Memory.PeakSysBytes = m.Sys
+	}
+}
+
+// Close stops the memory sampler. It is safe to call more than once.
+func (r *Report) Close() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	select {
+	case <-r.stop:
+		r.mu.Unlock()
+		return
+	default:
+		close(r.stop)
+	}
+	r.mu.Unlock()
+
+	<-r.done
+
+	r.mu.Lock()
+	r.Finished = time.Now()
+	r.mu.Unlock()
+}
+
+func (r *Report) AddMissingTerrain(id string, count int, l Location) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mt, ok := r.MissingTerrain[id]
+	if !ok {
+		mt = &MissingTerrain{
+			Examples: make([]Location, 0),
+		}
+		r.MissingTerrain[id] = mt
+	}
+	mt.Count += count
+	if len(mt.Examples) < maxExamples {
+		mt.Examples = append(mt.Examples, l)
+	}
+}
+
+func (r *Report) AddUnknownColor(color, id string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.UnknownColors[color] = append(r.UnknownColors[color], id)
+}
+
+func (r *Report) AddUnknownSymbol(symbol, id string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.UnknownSymbols[symbol] = append(r.UnknownSymbols[symbol], id)
+}
+
+func (r *Report) AddMissingSymbol(id string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.MissingSymbols = append(r.MissingSymbols, id)
+}
+
+func (r *Report) SkipFile(path, reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.SkippedFiles = append(r.SkippedFiles, SkippedFile{
+		Path:   path,
+		Reason: reason,
+	})
+}
+
+func (r *Report) SkipEntry(path string, entry int, reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.SkippedEntries = append(r.SkippedEntries, SkippedEntry{
+		Path:   path,
+		Entry:  entry,
+		Reason: reason,
+	})
+}
+
+func (r *Report) AddUnsupportedVersion(path, version string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.UnsupportedVersions = append(r.UnsupportedVersions, UnsupportedVersion{
+		Path:    path,
+		Version: version,
+	})
+}
+
+func (r *Report) AddDecodeError(path, err string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.DecodeErrors = append(r.DecodeErrors, DecodeError{
+		Path:  path,
+		Error: err,
+	})
+}
+
+func (r *Report) AddCorruptChunk(character, path string, x, y int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.CorruptChunks = append(r.CorruptChunks, CorruptChunk{
+		Character: character,
+		Path:      path,
+		ChunkX:    x,
+		ChunkY:    y,
+	})
+}
+
+// Stage runs f and records how long it took under name.
+func (r *Report) Stage(name string, f func() error) error {
+	start := time.Now()
+	err := f()
+	if r == nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.Stages = append(r.Stages, Stage{
+		Name:    name,
+		Seconds: time.Since(start).Seconds(),
+	})
+	r.mu.Unlock()
+
+	r.sampleMemory()
+
+	return err
+}
+
+func (r *Report) Fail(err error) {
+	if r == nil || err == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Error = err.Error()
+}
+
+// Write closes the report and writes it to file as indented JSON.
+func (r *Report) Write(file string) error {
+	if r == nil {
+		return nil
+	}
+
+	r.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ids := range r.UnknownColors {
+		sort.Strings(ids)
+	}
+	for _, ids := range r.UnknownSymbols {
+		sort.Strings(ids)
+	}
+	sort.Strings(r.MissingSymbols)
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, b, 0644)
+}
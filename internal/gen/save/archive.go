@@ -0,0 +1,391 @@
+package save
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+var compressionExts = []string{".gz", ".zst", ".zstd"}
+
+var archiveExts = []string{".zip", ".tar.gz", ".tgz", ".tar.zst", ".tar"}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Open returns a filesystem rooted at the world directory of a save, along
+// with the world's name. The save may be a directory or a .zip, .tar, .tar.gz
+// or .tar.zst archive; archives are searched for the directory holding
+// mods.json. The returned closer must be closed once the save has been read.
+func Open(save string) (fs.FS, string, io.Closer, error) {
+	info, err := os.Stat(save)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if info.IsDir() {
+		return os.DirFS(save), filepath.Base(save), nopCloser{}, nil
+	}
+
+	archiveName := trimArchiveExt(filepath.Base(save))
+	lower := strings.ToLower(save)
+
+	var fsys fs.FS
+	var closer io.Closer = nopCloser{}
+
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		z, err := zip.OpenReader(save)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		fsys = z
+		closer = z
+	case strings.HasSuffix(lower, ".tar"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"), strings.HasSuffix(lower, ".tar.zst"):
+		f, err := os.Open(save)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		defer f.Close()
+
+		fsys, err = tarFS(f)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("%v: %v", save, err)
+		}
+	default:
+		return nil, "", nil, fmt.Errorf("%v: not a directory or a supported archive", save)
+	}
+
+	root, err := worldRoot(fsys)
+	if err != nil {
+		closer.Close()
+		return nil, "", nil, fmt.Errorf("%v: %v", save, err)
+	}
+
+	if root == "." {
+		return fsys, archiveName, closer, nil
+	}
+
+	sub, err := fs.Sub(fsys, root)
+	if err != nil {
+		closer.Close()
+		return nil, "", nil, err
+	}
+
+	return sub, path.Base(root), closer, nil
+}
+
+// IsArchive reports whether file has an extension Open reads as an archive.
+func IsArchive(file string) bool {
+	lower := strings.ToLower(file)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func trimArchiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+func trimCompressionExt(name string) string {
+	for _, ext := range compressionExts {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+// worldRoot finds the world directory of an archive, the directory holding
+// its mods.json. An archive of several worlds is refused, since there's no
+// telling which one was meant.
+func worldRoot(fsys fs.FS) (string, error) {
+	roots := make([]string, 0)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != "mods.json" {
+			return nil
+		}
+
+		roots = append(roots, path.Dir(p))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch len(roots) {
+	case 0:
+		return "", fmt.Errorf("no world directory with a mods.json found")
+	case 1:
+		return roots[0], nil
+	default:
+		return "", fmt.Errorf("archive holds %v worlds, expected one: %v", len(roots), strings.Join(roots, ", "))
+	}
+}
+
+func tarFS(f io.Reader) (fs.FS, error) {
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := newMemFS()
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		fsys.add(strings.TrimPrefix(path.Clean(h.Name), "/"), b, h.ModTime)
+	}
+
+	return fsys, nil
+}
+
+// memFS is a read-only file system over files held in memory, such as the
+// entries of a tar archive. Directories are implied by the paths of the files.
+type memFS struct {
+	entries map[string]*memEntry
+}
+
+// memEntry is a file or directory of a memFS, and its fs.FileInfo and
+// fs.DirEntry.
+type memEntry struct {
+	name     string
+	data     []byte
+	modTime  time.Time
+	dir      bool
+	children []*memEntry
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		entries: map[string]*memEntry{
+			".": {name: ".", dir: true},
+		},
+	}
+}
+
+// add adds a file, along with any of its parent directories that are
+// missing. Files with invalid names or clashing with a directory are ignored.
+func (m *memFS) add(name string, data []byte, modTime time.Time) {
+	if name == "." || !fs.ValidPath(name) {
+		return
+	}
+	if _, ok := m.entries[name]; ok {
+		return
+	}
+
+	parent := m.dir(path.Dir(name))
+	if parent == nil {
+		return
+	}
+
+	e := &memEntry{name: path.Base(name), data: data, modTime: modTime}
+	m.entries[name] = e
+	parent.children = append(parent.children, e)
+}
+
+func (m *memFS) dir(name string) *memEntry {
+	if e, ok := m.entries[name]; ok {
+		if !e.dir {
+			return nil
+		}
+		return e
+	}
+
+	parent := m.dir(path.Dir(name))
+	if parent == nil {
+		return nil
+	}
+
+	e := &memEntry{name: path.Base(name), dir: true}
+	m.entries[name] = e
+	parent.children = append(parent.children, e)
+	return e
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e, ok := m.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if e.dir {
+		return &memDir{memEntry: e}, nil
+	}
+	return &memFile{memEntry: e, Reader: bytes.NewReader(e.data)}, nil
+}
+
+func (e *memEntry) Name() string {
+	return e.name
+}
+
+func (e *memEntry) Size() int64 {
+	return int64(len(e.data))
+}
+
+func (e *memEntry) Mode() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (e *memEntry) ModTime() time.Time {
+	return e.modTime
+}
+
+func (e *memEntry) IsDir() bool {
+	return e.dir
+}
+
+func (e *memEntry) Sys() interface{} {
+	return nil
+}
+
+func (e *memEntry) Type() fs.FileMode {
+	return e.Mode().Type()
+}
+
+func (e *memEntry) Info() (fs.FileInfo, error) {
+	return e, nil
+}
+
+type memFile struct {
+	*memEntry
+	*bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.memEntry, nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+type memDir struct {
+	*memEntry
+	read int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) {
+	return d.memEntry, nil
+}
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) Close() error {
+	return nil
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	left := d.children[d.read:]
+	if n > 0 && len(left) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(left) {
+		left = left[:n]
+	}
+
+	entries := make([]fs.DirEntry, len(left))
+	for i, e := range left {
+		entries[i] = e
+	}
+	d.read += len(left)
+	return entries, nil
+}
+
+// decompress wraps r in a gzip or zstd reader when its content starts with
+// the matching magic number, and returns it unchanged otherwise.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, zstdMagic):
+		d, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+
+	return br, nil
+}
+
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,128 @@
+package save
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestTarFS(t *testing.T) {
+	files := map[string]string{
+		"save/W/mods.json":  `["dda"]`,
+		"save/W/o.0.0":      "# version 26\n{}",
+		"save/W/o.1.-1.gz":  "compressed",
+		"/save/W/#SGFucw==": "character",
+		"save/W/../escape":  "cleaned",
+	}
+
+	var b bytes.Buffer
+	z := gzip.NewWriter(&b)
+	tw := tar.NewWriter(z)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			ModTime:  time.Unix(1500000000, 0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := tarFS(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fstest.TestFS(fsys, "save/W/mods.json", "save/W/o.0.0", "save/W/o.1.-1.gz", "save/W/#SGFucw==", "save/escape")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := worldRoot(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != "save/W" {
+		t.Errorf("got world root %q, want save/W", root)
+	}
+
+	data, err := fs.ReadFile(fsys, "save/W/mods.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `["dda"]` {
+		t.Errorf("got mods.json %q", data)
+	}
+}
+
+func TestWorldRoot(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("[]")}
+
+	tests := []struct {
+		name  string
+		files []string
+		root  string
+		err   string
+	}{
+		{
+			name:  "world at the top",
+			files: []string{"mods.json", "o.0.0"},
+			root:  ".",
+		},
+		{
+			name:  "world in a folder",
+			files: []string{"save/W/mods.json", "save/W/o.0.0", "readme.txt"},
+			root:  "save/W",
+		},
+		{
+			name:  "no world",
+			files: []string{"save/W/o.0.0"},
+			err:   "no world directory",
+		},
+		{
+			name:  "several worlds",
+			files: []string{"save/A/mods.json", "save/B/mods.json", "save/B/o.0.0"},
+			err:   "archive holds 2 worlds, expected one: save/A, save/B",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, f := range tt.files {
+				fsys[f] = file
+			}
+
+			root, err := worldRoot(fsys)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root != tt.root {
+				t.Errorf("got world root %q, want %q", root, tt.root)
+			}
+		})
+	}
+}
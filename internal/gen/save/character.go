@@ -0,0 +1,144 @@
+package save
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ralreegorganon/cddamap/internal/gen/coords"
+)
+
+// Character is a player character of the world. ID is the prefix shared by
+// the character's .sav and seen files.
+type Character struct {
+	ID   string
+	Name string
+	// Located is false when the character has no readable .sav file, in
+	// which case only ID and Name are set.
+	Located bool
+	// OvermapX and OvermapY are the absolute overmap terrain coordinates of
+	// the character, i.e. chunk coordinate * 180 + position in the chunk.
+	OvermapX int
+	OvermapY int
+	Z        int
+	Turn     int
+}
+
+type characterSave struct {
+	Turn   int `json:"turn"`
+	LevX   int `json:"levx"`
+	LevY   int `json:"levy"`
+	LevZ   int `json:"levz"`
+	OmX    int `json:"om_x"`
+	OmY    int `json:"om_y"`
+	Player struct {
+		Name string `json:"name"`
+		PosX int    `json:"posx"`
+		PosY int    `json:"posy"`
+	} `json:"player"`
+}
+
+// DecodeCharacterName turns a save file prefix such as "#SGFucw==" back into
+// the character name it was derived from, returning the prefix unchanged when
+// it isn't encoded.
+func DecodeCharacterName(id string) string {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(id, "#"))
+	if err != nil || len(b) == 0 {
+		return id
+	}
+	return string(b)
+}
+
+var characterSaveRegexp = regexp.MustCompile(`\.sav$`)
+
+func charactersFromSave(fsys fs.FS, seen map[string]Seen) (map[string]Character, DecodeErrors, error) {
+	characters := make(map[string]Character)
+
+	var files []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if characterSaveRegexp.MatchString(trimCompressionExt(p)) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var decodeErrors DecodeErrors
+
+	for _, f := range files {
+		id := strings.TrimSuffix(path.Base(trimCompressionExt(f)), ".sav")
+
+		c, err := readCharacterSave(fsys, f, id)
+		if err != nil {
+			decodeErrors = append(decodeErrors, &DecodeError{Path: f, Err: err})
+			continue
+		}
+		characters[id] = c
+	}
+
+	for id := range seen {
+		if _, ok := characters[id]; !ok {
+			characters[id] = Character{
+				ID:   id,
+				Name: DecodeCharacterName(id),
+			}
+		}
+	}
+
+	return characters, decodeErrors, nil
+}
+
+func readCharacterSave(fsys fs.FS, file, id string) (Character, error) {
+	b, err := readFile(fsys, file)
+	if err != nil {
+		return Character{}, err
+	}
+
+	// The first line is a version comment; the player format has been stable
+	// enough across versions for the fields read here.
+	if strings.HasPrefix(string(b), "#") {
+		if i := strings.IndexByte(string(b), '\n'); i >= 0 {
+			b = b[i+1:]
+		}
+	}
+
+	var cs characterSave
+	err = json.Unmarshal(b, &cs)
+	if err != nil {
+		return Character{}, err
+	}
+
+	name := cs.Player.Name
+	if name == "" {
+		name = DecodeCharacterName(id)
+	}
+
+	// lev is the submap relative to the character's chunk
+	o := coords.Submap{
+		X: cs.LevX + coords.FloorDiv(cs.Player.PosX, coords.SquaresPerSubmap),
+		Y: cs.LevY + coords.FloorDiv(cs.Player.PosY, coords.SquaresPerSubmap),
+	}.OvermapTerrain()
+	origin := coords.Chunk{X: cs.OmX, Y: cs.OmY}.Origin()
+
+	c := Character{
+		ID:       id,
+		Name:     name,
+		Located:  true,
+		OvermapX: origin.X + o.X,
+		OvermapY: origin.Y + o.Y,
+		Z:        cs.LevZ,
+		Turn:     cs.Turn,
+	}
+	return c, nil
+}
@@ -5,23 +5,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/ralreegorganon/cddamap/internal/gen/report"
+	log "github.com/sirupsen/logrus"
 )
 
 type Save struct {
-	Name    string
-	Mods    []string
-	Overmap Overmap
-	Seen    map[string]Seen
+	Name       string
+	Mods       []string
+	Overmap    Overmap
+	Seen       map[string]Seen
+	Characters map[string]Character
 }
 
 type Overmap struct {
-	Chunks []OvermapChunk
+	Chunks  []OvermapChunk
+	Corrupt []CorruptChunk
 }
 
 type OvermapChunk struct {
@@ -54,6 +59,7 @@ type City struct {
 type Seen struct {
 	Character string
 	Chunks    []SeenChunk
+	Corrupt   []CorruptChunk
 }
 
 type SeenChunk struct {
@@ -61,6 +67,17 @@ type SeenChunk struct {
 	Y        int
 	Visible  [][]SeenGroup `json:"visible"`
 	Explored [][]SeenGroup `json:"explored"`
+	Notes    [][]Note      `json:"notes"`
+}
+
+// Note is a map note written by the player, positioned within its chunk
+// layer.
+type Note struct {
+	X            int
+	Y            int
+	Text         string
+	Dangerous    bool
+	DangerRadius int
 }
 
 type SeenGroup struct {
@@ -68,37 +85,208 @@ type SeenGroup struct {
 	Count float64
 }
 
+// CorruptChunk is a chunk file that could not be decoded in Lenient mode.
+type CorruptChunk struct {
+	Path string
+	X    int
+	Y    int
+	Err  error
+}
+
+// Mode controls how Build handles chunk files that can't be decoded.
+type Mode int
+
+const (
+	// Strict decodes every chunk file and fails with all of the errors found.
+	Strict Mode = iota
+	// Lenient records chunk files that can't be decoded and skips them.
+	Lenient
+)
+
+type DecodeError struct {
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Path, e.Err)
+}
+
+type DecodeErrors []*DecodeError
+
+func (e DecodeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, de := range e {
+		msgs[i] = de.Error()
+	}
+	return fmt.Sprintf("%v save files could not be decoded:\n%v", len(e), strings.Join(msgs, "\n"))
+}
+
+const (
+	chunkLayers = 21
+	chunkCells  = 32400
+)
+
 func (tg *TerrainGroup) UnmarshalJSON(bs []byte) error {
 	arr := []interface{}{}
-	json.Unmarshal(bs, &arr)
-	tg.OvermapTerrainID = arr[0].(string)
-	tg.Count = arr[1].(float64)
+	if err := json.Unmarshal(bs, &arr); err != nil {
+		return err
+	}
+	if len(arr) != 2 {
+		return fmt.Errorf("terrain group %s: expected [id, count]", bs)
+	}
+
+	id, ok := arr[0].(string)
+	if !ok {
+		return fmt.Errorf("terrain group %s: id is not a string", bs)
+	}
+	count, ok := arr[1].(float64)
+	if !ok || count < 0 {
+		return fmt.Errorf("terrain group %s: count is not a positive number", bs)
+	}
+
+	tg.OvermapTerrainID = id
+	tg.Count = count
 	return nil
 }
 
 func (sg *SeenGroup) UnmarshalJSON(bs []byte) error {
 	arr := []interface{}{}
-	json.Unmarshal(bs, &arr)
-	sg.Seen = arr[0].(bool)
-	sg.Count = arr[1].(float64)
+	if err := json.Unmarshal(bs, &arr); err != nil {
+		return err
+	}
+	if len(arr) != 2 {
+		return fmt.Errorf("seen group %s: expected [seen, count]", bs)
+	}
+
+	seen, ok := arr[0].(bool)
+	if !ok {
+		return fmt.Errorf("seen group %s: seen is not a bool", bs)
+	}
+	count, ok := arr[1].(float64)
+	if !ok || count < 0 {
+		return fmt.Errorf("seen group %s: count is not a positive number", bs)
+	}
+
+	sg.Seen = seen
+	sg.Count = count
 	return nil
 }
 
-func Build(save string) (Save, error) {
+// UnmarshalJSON reads a note stored as [x, y, text] or, in newer saves,
+// [x, y, text, dangerous, danger_radius].
+func (n *Note) UnmarshalJSON(bs []byte) error {
+	arr := []interface{}{}
+	if err := json.Unmarshal(bs, &arr); err != nil {
+		return err
+	}
+	if len(arr) < 3 {
+		return fmt.Errorf("note %s: expected [x, y, text]", bs)
+	}
+
+	x, xok := arr[0].(float64)
+	y, yok := arr[1].(float64)
+	if !xok || !yok || x < 0 || x >= 180 || y < 0 || y >= 180 {
+		return fmt.Errorf("note %s: position is not within the chunk", bs)
+	}
+	text, ok := arr[2].(string)
+	if !ok {
+		return fmt.Errorf("note %s: text is not a string", bs)
+	}
+
+	n.X = int(x)
+	n.Y = int(y)
+	n.Text = text
+
+	if len(arr) >= 5 {
+		dangerous, dok := arr[3].(bool)
+		radius, rok := arr[4].(float64)
+		if !dok || !rok {
+			return fmt.Errorf("note %s: expected dangerous bool and danger radius", bs)
+		}
+		n.Dangerous = dangerous
+		n.DangerRadius = int(radius)
+	}
+	return nil
+}
+
+func validateLayerCounts(counts []int) error {
+	if len(counts) != chunkLayers {
+		return fmt.Errorf("expected %v layers, found %v", chunkLayers, len(counts))
+	}
+	for li, c := range counts {
+		if c != chunkCells {
+			return fmt.Errorf("layer %v has %v cells, expected %v", li, c, chunkCells)
+		}
+	}
+	return nil
+}
+
+// Build reads the save at path, which is either a world directory or an
+// archive containing one.
+func Build(save string, mode Mode, r *report.Report) (Save, error) {
+	fsys, name, closer, err := Open(save)
+	if err != nil {
+		return Save{}, err
+	}
+	defer closer.Close()
+
+	return BuildFS(fsys, name, mode, r)
+}
+
+// BuildFS reads a save from the root of fsys.
+func BuildFS(fsys fs.FS, name string, mode Mode, r *report.Report) (Save, error) {
 	s := Save{}
 
-	o, err := overmapFromSave(save)
+	o, decodeErrors, err := overmapFromSave(fsys, r)
+	if err != nil {
+		return s, err
+	}
+
+	cs, seenErrors, err := characterSeenFromSave(fsys, r)
 	if err != nil {
 		return s, err
 	}
+	decodeErrors = append(decodeErrors, seenErrors...)
 
-	cs, err := characterSeenFromSave(save)
+	characters, characterErrors, err := charactersFromSave(fsys, cs)
 	if err != nil {
 		return s, err
 	}
+	decodeErrors = append(decodeErrors, characterErrors...)
+
+	for _, de := range decodeErrors {
+		r.AddDecodeError(de.Path, de.Err.Error())
+	}
+
+	if len(decodeErrors) > 0 {
+		if mode == Strict {
+			return s, decodeErrors
+		}
+		for _, de := range decodeErrors {
+			log.WithFields(log.Fields{
+				"file": de.Path,
+				"err":  de.Err,
+			}).Warn("skipping corrupt save file")
+		}
+
+		for _, c := range o.Corrupt {
+			r.AddCorruptChunk("", c.Path, c.X, c.Y)
+		}
+
+		names := make([]string, 0, len(cs))
+		for name := range cs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, c := range cs[name].Corrupt {
+				r.AddCorruptChunk(name, c.Path, c.X, c.Y)
+			}
+		}
+	}
 
-	saveModsPath := filepath.Join(save, "mods.json")
-	b, err := ioutil.ReadFile(saveModsPath)
+	b, err := readFile(fsys, "mods.json")
 	if err != nil {
 		return s, err
 	}
@@ -108,55 +296,61 @@ func Build(save string) (Save, error) {
 		return s, err
 	}
 
-	name := filepath.Base(save)
-
 	s = Save{
-		Name:    name,
-		Overmap: o,
-		Mods:    mods,
-		Seen:    cs,
+		Name:       name,
+		Overmap:    o,
+		Mods:       mods,
+		Seen:       cs,
+		Characters: characters,
 	}
 
 	return s, nil
 }
 
-func overmapFromSave(save string) (Overmap, error) {
-	o := Overmap{}
-	chunkFiles, err := overmapChunkFiles(save)
+func readChunkFile(fsys fs.FS, file, version string, v interface{}, r *report.Report) error {
+	t, err := readFile(fsys, file)
 	if err != nil {
-		return o, err
+		return err
 	}
 
-	chunks := make([]OvermapChunk, 0)
+	lines := strings.Split(string(t), "\n")
 
-	for _, f := range chunkFiles {
-		t, err := ioutil.ReadFile(f)
-		if err != nil {
-			return o, err
-		}
+	if lines[0] != version {
+		r.AddUnsupportedVersion(file, lines[0])
+		return fmt.Errorf("unsupported version: %v", lines[0])
+	}
 
-		lines := strings.Split(string(t), "\n")
+	var buffer bytes.Buffer
+	for i := 1; i < len(lines); i++ {
+		buffer.WriteString(lines[i])
+	}
 
-		if lines[0] != "# version 26" {
-			return o, fmt.Errorf("unsupported version: %v", lines[0])
-		}
+	return json.Unmarshal(buffer.Bytes(), v)
+}
 
-		var buffer bytes.Buffer
-		for i := 1; i < len(lines); i++ {
-			buffer.WriteString(lines[i])
-		}
+func overmapFromSave(fsys fs.FS, r *report.Report) (Overmap, DecodeErrors, error) {
+	o := Overmap{}
+	chunkFiles, err := overmapChunkFiles(fsys)
+	if err != nil {
+		return o, nil, err
+	}
 
-		pruned := buffer.Bytes()
+	chunks := make([]OvermapChunk, 0)
+	corrupt := make([]CorruptChunk, 0)
+	var decodeErrors DecodeErrors
 
-		var chunk OvermapChunk
-		err = json.Unmarshal(pruned, &chunk)
+	for _, f := range chunkFiles {
+		x, y, err := chunkFileNameToCoordinates(f)
 		if err != nil {
-			return o, err
+			decodeErrors = append(decodeErrors, &DecodeError{Path: f, Err: err})
+			continue
 		}
 
-		x, y, err := chunkFileNameToCoordinates(f)
+		chunk, err := readOvermapChunk(fsys, f, r)
 		if err != nil {
-			return o, err
+			decodeErrors = append(decodeErrors, &DecodeError{Path: f, Err: err})
+			corrupt = append(corrupt, CorruptChunk{Path: f, X: x, Y: y, Err: err})
+			continue
 		}
 		chunk.X = x
 		chunk.Y = y
@@ -165,26 +359,48 @@ func overmapFromSave(save string) (Overmap, error) {
 	}
 
 	o = Overmap{
-		Chunks: chunks,
+		Chunks:  chunks,
+		Corrupt: corrupt,
 	}
-	return o, nil
+	return o, decodeErrors, nil
 }
 
-func overmapChunkFiles(root string) ([]string, error) {
+func readOvermapChunk(fsys fs.FS, file string, r *report.Report) (OvermapChunk, error) {
+	var chunk OvermapChunk
+	err := readChunkFile(fsys, file, "# version 26", &chunk, r)
+	if err != nil {
+		return chunk, err
+	}
+
+	counts := make([]int, len(chunk.Layers))
+	for li, l := range chunk.Layers {
+		for _, e := range l {
+			counts[li] += int(e.Count)
+		}
+	}
+	if err := validateLayerCounts(counts); err != nil {
+		return chunk, err
+	}
+
+	return chunk, nil
+}
+
+var overmapChunkRegexp = regexp.MustCompile(`(^|/)o\.-?\d+\.-?\d+$`)
+
+func overmapChunkFiles(fsys fs.FS) ([]string, error) {
 	files := []string{}
-	re := regexp.MustCompile(`o\.-?\d\.-?\d$`)
 
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if d.IsDir() {
 			return nil
 		}
 
-		isOvermapChunk := re.MatchString(path)
+		isOvermapChunk := overmapChunkRegexp.MatchString(trimCompressionExt(p))
 		if isOvermapChunk {
-			files = append(files, path)
+			files = append(files, p)
 		}
 
 		return nil
@@ -197,7 +413,7 @@ func overmapChunkFiles(root string) ([]string, error) {
 }
 
 func chunkFileNameToCoordinates(chunkFile string) (int, int, error) {
-	_, file := filepath.Split(chunkFile)
+	file := path.Base(trimCompressionExt(chunkFile))
 	parts := strings.Split(file, ".")
 	x, err := strconv.Atoi(parts[1])
 	if err != nil {
@@ -210,79 +426,101 @@ func chunkFileNameToCoordinates(chunkFile string) (int, int, error) {
 	return x, y, nil
 }
 
-func characterSeenFromSave(save string) (map[string]Seen, error) {
+func characterSeenFromSave(fsys fs.FS, r *report.Report) (map[string]Seen, DecodeErrors, error) {
 	s := make(map[string]Seen)
 
-	chunkFiles, err := characterSeenChunkFiles(save)
+	chunkFiles, err := characterSeenChunkFiles(fsys)
 	if err != nil {
-		return s, err
+		return s, nil, err
 	}
 
-	for _, f := range chunkFiles {
-		t, err := ioutil.ReadFile(f)
-		if err != nil {
-			return s, err
-		}
-
-		lines := strings.Split(string(t), "\n")
+	var decodeErrors DecodeErrors
 
-		if lines[0] != "# version 25" {
-			return s, fmt.Errorf("unsupported version: %v", lines[0])
-		}
-
-		var buffer bytes.Buffer
-		for i := 1; i < len(lines); i++ {
-			buffer.WriteString(lines[i])
-		}
-
-		pruned := buffer.Bytes()
-
-		var chunk SeenChunk
-		err = json.Unmarshal(pruned, &chunk)
-		if err != nil {
-			return s, err
-		}
-
-		parts := strings.Split(filepath.Base(f), ".")
+	for _, f := range chunkFiles {
+		parts := strings.Split(path.Base(f), ".")
 		name := parts[0]
 
 		if _, ok := s[name]; !ok {
 			s[name] = Seen{
 				Character: name,
 				Chunks:    make([]SeenChunk, 0),
+				Corrupt:   make([]CorruptChunk, 0),
 			}
 		}
 
 		x, y, err := characterSeenFileNameToCoordinates(f)
 		if err != nil {
-			return s, err
+			decodeErrors = append(decodeErrors, &DecodeError{Path: f, Err: err})
+			continue
+		}
+
+		seen := s[name]
+
+		chunk, err := readSeenChunk(fsys, f, r)
+		if err != nil {
+			decodeErrors = append(decodeErrors, &DecodeError{Path: f, Err: err})
+			seen.Corrupt = append(seen.Corrupt, CorruptChunk{Path: f, X: x, Y: y, Err: err})
+			s[name] = seen
+			continue
 		}
 		chunk.X = x
 		chunk.Y = y
 
-		seen := s[name]
 		seen.Chunks = append(seen.Chunks, chunk)
 		s[name] = seen
 	}
 
-	return s, nil
+	return s, decodeErrors, nil
+}
+
+func readSeenChunk(fsys fs.FS, file string, r *report.Report) (SeenChunk, error) {
+	var chunk SeenChunk
+	err := readChunkFile(fsys, file, "# version 25", &chunk, r)
+	if err != nil {
+		return chunk, err
+	}
+
+	counts := make([]int, len(chunk.Visible))
+	for li, l := range chunk.Visible {
+		for _, e := range l {
+			counts[li] += int(e.Count)
+		}
+	}
+	if err := validateLayerCounts(counts); err != nil {
+		return chunk, fmt.Errorf("visible: %v", err)
+	}
+
+	if len(chunk.Explored) > 0 {
+		counts = make([]int, len(chunk.Explored))
+		for li, l := range chunk.Explored {
+			for _, e := range l {
+				counts[li] += int(e.Count)
+			}
+		}
+		if err := validateLayerCounts(counts); err != nil {
+			return chunk, fmt.Errorf("explored: %v", err)
+		}
+	}
+
+	return chunk, nil
 }
 
-func characterSeenChunkFiles(root string) ([]string, error) {
+var characterSeenChunkRegexp = regexp.MustCompile(`\.seen\.-?\d+\.-?\d+$`)
+
+func characterSeenChunkFiles(fsys fs.FS) ([]string, error) {
 	files := []string{}
-	re := regexp.MustCompile(`\.seen\.-?\d\.-?\d$`)
 
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if d.IsDir() {
 			return nil
 		}
 
-		isCharacterSeenChunk := re.MatchString(path)
+		isCharacterSeenChunk := characterSeenChunkRegexp.MatchString(trimCompressionExt(p))
 		if isCharacterSeenChunk {
-			files = append(files, path)
+			files = append(files, p)
 		}
 
 		return nil
@@ -295,13 +533,13 @@ func characterSeenChunkFiles(root string) ([]string, error) {
 }
 
 func characterSeenFileNameToCoordinates(chunkFile string) (int, int, error) {
-	_, file := filepath.Split(chunkFile)
+	file := path.Base(trimCompressionExt(chunkFile))
 	parts := strings.Split(file, ".")
-	x, err := strconv.Atoi(parts[2])
+	x, err := strconv.Atoi(parts[len(parts)-2])
 	if err != nil {
 		return 0, 0, err
 	}
-	y, err := strconv.Atoi(parts[3])
+	y, err := strconv.Atoi(parts[len(parts)-1])
 	if err != nil {
 		return 0, 0, err
 	}
@@ -0,0 +1,258 @@
+package world
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CombineMode selects how CombineSeen merges the seen layers of characters.
+type CombineMode int
+
+const (
+	// Union is seen where any of the characters has seen.
+	Union CombineMode = iota
+	// Intersection is seen where all of the characters have seen.
+	Intersection
+)
+
+func (m CombineMode) String() string {
+	switch m {
+	case Union:
+		return "union"
+	case Intersection:
+		return "intersection"
+	}
+	return fmt.Sprintf("CombineMode(%d)", int(m))
+}
+
+// ParseCombineMode parses "union" or "intersection".
+func ParseCombineMode(s string) (CombineMode, error) {
+	switch strings.ToLower(s) {
+	case "union":
+		return Union, nil
+	case "intersection":
+		return Intersection, nil
+	}
+	return 0, fmt.Errorf("unknown seen combination %q, expected union or intersection", s)
+}
+
+// CombinedSeen is the seen layers of several characters merged into one.
+type CombinedSeen struct {
+	Mode       CombineMode
+	Characters []string
+	Layers     []SeenLayer
+	Explored   []SeenLayer
+}
+
+// CombineSeen merges the seen layers of the given characters, named by ID or
+// by name, or of every character when none are given, and adds the result to
+// w.CombinedSeen. It returns the name the combination is stored under, which
+// is the mode followed by "_all" or by the character IDs.
+func CombineSeen(w *World, mode CombineMode, characters []string) (string, error) {
+	ids, err := resolveCharacters(*w, characters)
+	if err != nil {
+		return "", err
+	}
+
+	name := mode.String() + "_all"
+	if len(characters) > 0 {
+		name = mode.String() + "_" + strings.Join(ids, "+")
+	}
+
+	visible := make([][]SeenLayer, 0, len(ids))
+	explored := make([][]SeenLayer, 0, len(ids))
+	for _, id := range ids {
+		visible = append(visible, w.SeenLayers[id])
+		explored = append(explored, w.ExploredLayers[id])
+	}
+
+	if w.CombinedSeen == nil {
+		w.CombinedSeen = make(map[string]CombinedSeen)
+	}
+	w.CombinedSeen[name] = CombinedSeen{
+		Mode:       mode,
+		Characters: ids,
+		Layers:     combineLayers(*w, visible, mode),
+		Explored:   combineLayers(*w, explored, mode),
+	}
+
+	return name, nil
+}
+
+func combineLayers(w World, sources [][]SeenLayer, mode CombineMode) []SeenLayer {
+	var layers []SeenLayer
+	for _, cl := range sources {
+		if layers == nil {
+			layers = copySeenLayers(cl)
+			continue
+		}
+
+		for li := range layers {
+			// a chunk missing for any of the characters leaves the
+			// combination unknown there
+			layers[li].Corrupt = addChunks(layers[li].Corrupt, cl[li].Corrupt)
+
+			for ri := range layers[li].SeenRows {
+				row := layers[li].SeenRows[ri].SeenCellKeys
+				other := cl[li].SeenRows[ri].SeenCellKeys
+				for ci := range row {
+					if mode == Union {
+						row[ci] = row[ci] || other[ci]
+					} else {
+						row[ci] = row[ci] && other[ci]
+					}
+				}
+			}
+		}
+	}
+
+	if layers == nil {
+		layers = emptySeenLayers(w)
+	}
+
+	for li := range layers {
+		empty := len(layers[li].Corrupt) == 0
+		for _, r := range layers[li].SeenRows {
+			for _, k := range r.SeenCellKeys {
+				if k {
+					empty = false
+					break
+				}
+			}
+			if !empty {
+				break
+			}
+		}
+		layers[li].Empty = empty
+	}
+
+	return layers
+}
+
+// AllSeenLayers returns the seen layers of every character along with those
+// of every combination, keyed by character ID or combination name.
+func (w World) AllSeenLayers() map[string][]SeenLayer {
+	all := make(map[string][]SeenLayer, len(w.SeenLayers)+len(w.CombinedSeen))
+	for id, layers := range w.SeenLayers {
+		all[id] = layers
+	}
+	for name, cs := range w.CombinedSeen {
+		all[name] = cs.Layers
+	}
+	return all
+}
+
+// AllExploredLayers is AllSeenLayers for the explored rather than the visible
+// layers.
+func (w World) AllExploredLayers() map[string][]SeenLayer {
+	all := make(map[string][]SeenLayer, len(w.ExploredLayers)+len(w.CombinedSeen))
+	for id, layers := range w.ExploredLayers {
+		all[id] = layers
+	}
+	for name, cs := range w.CombinedSeen {
+		all[name] = cs.Explored
+	}
+	return all
+}
+
+// SeenCharacters returns the located characters whose positions belong on the
+// seen layers stored under name: the character itself, or every character of
+// a combination.
+func (w World) SeenCharacters(name string) []Character {
+	ids := []string{name}
+	if cs, ok := w.CombinedSeen[name]; ok {
+		ids = cs.Characters
+	}
+
+	characters := make([]Character, 0)
+	for _, id := range ids {
+		if c, ok := w.Characters[id]; ok && c.Located {
+			characters = append(characters, c)
+		}
+	}
+	return characters
+}
+
+// resolveCharacters returns the sorted IDs of the named characters that have
+// seen layers, or of all such characters when names is empty.
+func resolveCharacters(w World, names []string) ([]string, error) {
+	ids := make([]string, 0)
+
+	if len(names) == 0 {
+		for id := range w.SeenLayers {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return ids, nil
+	}
+
+	found := make(map[string]bool)
+	for _, n := range names {
+		id := ""
+		if _, ok := w.SeenLayers[n]; ok {
+			id = n
+		} else {
+			for cid, c := range w.Characters {
+				if c.Name == n {
+					if _, ok := w.SeenLayers[cid]; ok {
+						id = cid
+						break
+					}
+				}
+			}
+		}
+
+		if id == "" {
+			return nil, fmt.Errorf("character %q has no seen layers in world %v", n, w.Name)
+		}
+		if !found[id] {
+			found[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func copySeenLayers(layers []SeenLayer) []SeenLayer {
+	c := make([]SeenLayer, len(layers))
+	for li, l := range layers {
+		c[li].Empty = l.Empty
+		c[li].Corrupt = append([][2]int(nil), l.Corrupt...)
+		c[li].SeenRows = make([]SeenRow, len(l.SeenRows))
+		for ri, r := range l.SeenRows {
+			c[li].SeenRows[ri].SeenCellKeys = append([]bool(nil), r.SeenCellKeys...)
+		}
+	}
+	return c
+}
+
+func emptySeenLayers(w World) []SeenLayer {
+	layers := make([]SeenLayer, len(w.TerrainLayers))
+	for li, tl := range w.TerrainLayers {
+		layers[li].SeenRows = make([]SeenRow, len(tl.TerrainRows))
+		for ri, r := range tl.TerrainRows {
+			layers[li].SeenRows[ri].SeenCellKeys = make([]bool, len(r.TerrainCellKeys))
+		}
+	}
+	return layers
+}
+
+// addChunks adds the chunks of other that chunks doesn't have yet.
+func addChunks(chunks, other [][2]int) [][2]int {
+	for _, o := range other {
+		found := false
+		for _, c := range chunks {
+			if c == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			chunks = append(chunks, o)
+		}
+	}
+	return chunks
+}
@@ -0,0 +1,92 @@
+package world
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCombineSeen(t *testing.T) {
+	// layer builds a one layer seen area from rows of "x" for seen cells and
+	// "." for unseen ones.
+	layer := func(corrupt [][2]int, rows ...string) []SeenLayer {
+		l := SeenLayer{Empty: true, Corrupt: corrupt}
+		for _, r := range rows {
+			keys := make([]bool, len(r))
+			for i, c := range r {
+				keys[i] = c == 'x'
+				if keys[i] {
+					l.Empty = false
+				}
+			}
+			l.SeenRows = append(l.SeenRows, SeenRow{SeenCellKeys: keys})
+		}
+		if len(corrupt) > 0 {
+			l.Empty = false
+		}
+		return []SeenLayer{l}
+	}
+
+	w := World{
+		TerrainLayers: []TerrainLayer{{TerrainRows: []TerrainRow{{TerrainCellKeys: make([]uint32, 3)}}}},
+		SeenLayers: map[string][]SeenLayer{
+			"a": layer([][2]int{{0, 0}}, "xx."),
+			"b": layer([][2]int{{1, 0}, {0, 0}}, ".x."),
+			"c": layer(nil, "..."),
+		},
+		Characters: map[string]Character{
+			"a": {ID: "a", Name: "Anna"},
+			"b": {ID: "b", Name: "Bert"},
+			"c": {ID: "c", Name: "Cleo"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		mode       CombineMode
+		characters []string
+		combined   string
+		want       []SeenLayer
+	}{
+		{
+			name:     "union",
+			mode:     Union,
+			combined: "union_all",
+			want:     layer([][2]int{{0, 0}, {1, 0}}, "xx."),
+		},
+		{
+			name:     "intersection",
+			mode:     Intersection,
+			combined: "intersection_all",
+			want:     layer([][2]int{{0, 0}, {1, 0}}, "..."),
+		},
+		{
+			name:       "by name",
+			mode:       Intersection,
+			characters: []string{"Anna", "b"},
+			combined:   "intersection_a+b",
+			want:       layer([][2]int{{0, 0}, {1, 0}}, ".x."),
+		},
+		{
+			name:       "nothing seen",
+			mode:       Union,
+			characters: []string{"c"},
+			combined:   "union_c",
+			want:       layer(nil, "..."),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := CombineSeen(&w, tt.mode, tt.characters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.combined {
+				t.Errorf("got name %q, want %q", name, tt.combined)
+			}
+			if got := w.CombinedSeen[name].Layers; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package world
+
+import (
+	"sort"
+)
+
+// Diff is what changed between two builds of the same world. Positions are
+// cells in the grid of the newer build.
+type Diff struct {
+	Terrain       []TerrainChange
+	Explored      map[string][]DiffCell
+	CitiesAdded   []City
+	CitiesRemoved []City
+}
+
+// DiffCell is a cell of the newer build along with its absolute overmap
+// terrain coordinates.
+type DiffCell struct {
+	Layer    int
+	X        int
+	Y        int
+	OvermapX int
+	OvermapY int
+}
+
+// TerrainChange is a cell whose terrain differs between the builds.
+type TerrainChange struct {
+	DiffCell
+	From TerrainCell
+	To   TerrainCell
+}
+
+// Empty reports whether nothing changed.
+func (d Diff) Empty() bool {
+	if len(d.Terrain) > 0 || len(d.CitiesAdded) > 0 || len(d.CitiesRemoved) > 0 {
+		return false
+	}
+	for _, cells := range d.Explored {
+		if len(cells) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare diffs two builds of the same world, from an older one to a newer
+// one. The builds are lined up by absolute overmap position, so they may come
+// from saves with different names. Cells of chunks that only one of the builds has generated aren't
+// terrain changes; they only show up as newly explored.
+func Compare(from, to World, includeLayers []int) (Diff, error) {
+	d := Diff{
+		Terrain:       make([]TerrainChange, 0),
+		Explored:      make(map[string][]DiffCell),
+		CitiesAdded:   make([]City, 0),
+		CitiesRemoved: make([]City, 0),
+	}
+
+	for _, li := range includeLayers {
+		tl := to.TerrainLayers[li]
+		fl := from.TerrainLayers[li]
+
+		for ri, r := range tl.TerrainRows {
+			for ci, k := range r.TerrainCellKeys {
+				toCell := to.TerrainCellLookup[k]
+				if toCell.ID == "" {
+					continue
+				}
+
+				fk, ok := cellAt(from, fl, to.OriginX+ci, to.OriginY+ri)
+				if !ok {
+					continue
+				}
+				fromCell := from.TerrainCellLookup[fk]
+				if fromCell.ID == "" || fromCell.ID == toCell.ID {
+					continue
+				}
+
+				d.Terrain = append(d.Terrain, TerrainChange{
+					DiffCell: diffCell(to, li, ci, ri),
+					From:     fromCell,
+					To:       toCell,
+				})
+			}
+		}
+
+		for id, layers := range to.SeenLayers {
+			fromLayers := from.SeenLayers[id]
+
+			for ri, r := range layers[li].SeenRows {
+				for ci, seen := range r.SeenCellKeys {
+					if !seen {
+						continue
+					}
+					if fromLayers != nil && seenAt(from, fromLayers[li], to.OriginX+ci, to.OriginY+ri) {
+						continue
+					}
+					d.Explored[id] = append(d.Explored[id], diffCell(to, li, ci, ri))
+				}
+			}
+		}
+	}
+
+	d.CitiesAdded = cityDifference(to, from, to)
+	d.CitiesRemoved = cityDifference(from, to, to)
+
+	return d, nil
+}
+
+func diffCell(w World, layer, x, y int) DiffCell {
+	return DiffCell{
+		Layer:    layer,
+		X:        x,
+		Y:        y,
+		OvermapX: w.OriginX + x,
+		OvermapY: w.OriginY + y,
+	}
+}
+
+func cellAt(w World, l TerrainLayer, overmapX, overmapY int) (uint32, bool) {
+	x := overmapX - w.OriginX
+	y := overmapY - w.OriginY
+	if y < 0 || y >= len(l.TerrainRows) || x < 0 || x >= len(l.TerrainRows[y].TerrainCellKeys) {
+		return 0, false
+	}
+	return l.TerrainRows[y].TerrainCellKeys[x], true
+}
+
+func seenAt(w World, l SeenLayer, overmapX, overmapY int) bool {
+	x := overmapX - w.OriginX
+	y := overmapY - w.OriginY
+	if y < 0 || y >= len(l.SeenRows) || x < 0 || x >= len(l.SeenRows[y].SeenCellKeys) {
+		return false
+	}
+	return l.SeenRows[y].SeenCellKeys[x]
+}
+
+// cityDifference returns the cities of a that b doesn't have, positioned in
+// the grid of grid. Cities where grid hasn't generated any terrain are left
+// out, as they have no place in it.
+func cityDifference(a, b, grid World) []City {
+	type key struct {
+		name string
+		x, y int
+	}
+
+	inB := make(map[key]bool)
+	for _, c := range b.CityLayer.Cities {
+		inB[key{c.Name, b.OriginX + c.X, b.OriginY + c.Y}] = true
+	}
+
+	cities := make([]City, 0)
+	for _, c := range a.CityLayer.Cities {
+		x, y := a.OriginX+c.X, a.OriginY+c.Y
+		if inB[key{c.Name, x, y}] {
+			continue
+		}
+		k, ok := cellAt(grid, grid.TerrainLayers[10], x, y)
+		if !ok || grid.TerrainCellLookup[k].ID == "" {
+			continue
+		}
+		c.X = x - grid.OriginX
+		c.Y = y - grid.OriginY
+		cities = append(cities, c)
+	}
+
+	sort.Slice(cities, func(i, j int) bool {
+		return cities[i].Name < cities[j].Name
+	})
+	return cities
+}
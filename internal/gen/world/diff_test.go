@@ -0,0 +1,56 @@
+package world
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareCities(t *testing.T) {
+	// grid builds a world whose ground layer covers the rows at the origin,
+	// with "." for generated terrain and " " for chunks not generated yet.
+	grid := func(originX, originY int, rows []string, cities ...City) World {
+		w := World{
+			TerrainLayers:     make([]TerrainLayer, 21),
+			TerrainCellLookup: map[uint32]TerrainCell{0: {}, 1: {ID: "field"}},
+			CityLayer:         CityLayer{Cities: cities},
+			OriginX:           originX,
+			OriginY:           originY,
+		}
+		for _, r := range rows {
+			keys := make([]uint32, len(r))
+			for i, c := range r {
+				if c == '.' {
+					keys[i] = 1
+				}
+			}
+			w.TerrainLayers[10].TerrainRows = append(w.TerrainLayers[10].TerrainRows, TerrainRow{TerrainCellKeys: keys})
+		}
+		return w
+	}
+
+	from := grid(0, 0, []string{"...", "..."},
+		City{Name: "Kept", X: 1, Y: 0, Size: 2},
+		City{Name: "Gone", X: 1, Y: 1, Size: 3},
+		City{Name: "Outside", X: 0, Y: 0, Size: 1},
+		City{Name: "Ungenerated", X: 2, Y: 0, Size: 1},
+	)
+	to := grid(1, 0, []string{". .", "..."},
+		City{Name: "Kept", X: 0, Y: 0, Size: 2},
+		City{Name: "New", X: 2, Y: 1, Size: 4},
+	)
+
+	d, err := Compare(from, to, []int{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added := []City{{Name: "New", X: 2, Y: 1, Size: 4}}
+	if !reflect.DeepEqual(d.CitiesAdded, added) {
+		t.Errorf("got added cities %+v, want %+v", d.CitiesAdded, added)
+	}
+
+	removed := []City{{Name: "Gone", X: 0, Y: 1, Size: 3}}
+	if !reflect.DeepEqual(d.CitiesRemoved, removed) {
+		t.Errorf("got removed cities %+v, want %+v", d.CitiesRemoved, removed)
+	}
+}
@@ -0,0 +1,863 @@
+package world
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// FileName is what a world written next to its output is called.
+const FileName = "world.bin"
+
+// The world file starts with fileMagic and the format version, followed by a
+// deflated body of varints and length prefixed strings. Terrain rows are runs
+// of indexes into a palette of terrain keys, city rows runs of indexes into a
+// palette of city names, and seen rows are bitsets. The body ends with the
+// store of the world when it has one. Bump fileFormat whenever the body
+// changes.
+const (
+	fileMagic  = "CDDAWRLD"
+	fileFormat = 1
+)
+
+// maxLength bounds the lengths read from a world file, so a corrupt file
+// fails early on a length no world has. Lengths are never allocated up front:
+// slices grow as their entries are read and byte strings as their bytes
+// arrive, so a corrupt length runs into the end of the file instead of
+// asking for all the memory there is.
+const maxLength = 1 << 28
+
+// WriteFile writes the world to path.
+func WriteFile(path string, w World) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b := bufio.NewWriter(f)
+	err = w.Encode(b)
+	if err != nil {
+		return err
+	}
+
+	err = b.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFile reads a world written by WriteFile.
+func ReadFile(path string) (World, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return World{}, err
+	}
+	defer f.Close()
+
+	w, err := Decode(bufio.NewReader(f))
+	if err != nil {
+		return World{}, fmt.Errorf("%v: %v", path, err)
+	}
+	return w, nil
+}
+
+func (w World) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	err := w.Encode(&b)
+	return b.Bytes(), err
+}
+
+func (w *World) UnmarshalBinary(data []byte) error {
+	d, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	*w = d
+	return nil
+}
+
+// Encode writes the world in the world file format.
+func (w World) Encode(wr io.Writer) error {
+	_, err := io.WriteString(wr, fileMagic)
+	if err != nil {
+		return err
+	}
+	_, err = wr.Write([]byte{fileFormat})
+	if err != nil {
+		return err
+	}
+
+	fw, err := flate.NewWriter(wr, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+
+	e := &encoder{w: bufio.NewWriter(fw)}
+	e.world(w)
+	if e.err != nil {
+		return e.err
+	}
+
+	err = e.w.Flush()
+	if err != nil {
+		return err
+	}
+	return fw.Close()
+}
+
+// Decode reads a world in the world file format.
+func Decode(r io.Reader) (World, error) {
+	header := make([]byte, len(fileMagic)+1)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return World{}, err
+	}
+	if string(header[:len(fileMagic)]) != fileMagic {
+		return World{}, fmt.Errorf("not a world file")
+	}
+	if header[len(fileMagic)] != fileFormat {
+		return World{}, fmt.Errorf("world file format %v, expected %v", header[len(fileMagic)], fileFormat)
+	}
+
+	fr := flate.NewReader(r)
+	defer fr.Close()
+
+	d := &decoder{r: bufio.NewReader(fr)}
+	w := d.world()
+	if d.err != nil {
+		return World{}, d.err
+	}
+
+	// The body has to end with the world, so a cut off or padded file
+	// doesn't pass for a complete one.
+	_, err = d.r.ReadByte()
+	if err == nil {
+		return World{}, fmt.Errorf("unexpected data after the world")
+	}
+	if err != io.EOF {
+		return World{}, err
+	}
+	return w, nil
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (e *encoder) write(b []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(b)
+}
+
+func (e *encoder) uint(v uint64) {
+	e.write(e.buf[:binary.PutUvarint(e.buf[:], v)])
+}
+
+func (e *encoder) int(v int) {
+	e.write(e.buf[:binary.PutVarint(e.buf[:], int64(v))])
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.write([]byte{1})
+	} else {
+		e.write([]byte{0})
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *encoder) strings(s []string) {
+	e.uint(uint64(len(s)))
+	for _, v := range s {
+		e.string(v)
+	}
+}
+
+func (e *encoder) float(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	e.write(b[:])
+}
+
+func (e *encoder) color(c color.RGBA) {
+	e.write([]byte{c.R, c.G, c.B, c.A})
+}
+
+// runs writes a row of n palette indexes as runs of equal indexes.
+func (e *encoder) runs(n int, index func(i int) int) {
+	e.uint(uint64(n))
+	for i := 0; i < n; {
+		v := index(i)
+		j := i + 1
+		for j < n && index(j) == v {
+			j++
+		}
+		e.uint(uint64(j - i))
+		e.uint(uint64(v))
+		i = j
+	}
+}
+
+func (e *encoder) world(w World) {
+	e.string(w.Name)
+	e.int(w.OriginX)
+	e.int(w.OriginY)
+
+	e.terrain(w)
+	e.seenCells(w.SeenCellLookup)
+	e.seenLayerMap(w.SeenLayers)
+	e.seenLayerMap(w.ExploredLayers)
+	e.cityLayer(w.CityLayer)
+	e.characters(w.Characters)
+	e.notes(w.Notes)
+	e.combinedSeen(w.CombinedSeen)
+
+	e.bool(w.Store != nil)
+	if w.Store != nil {
+		e.store(*w.Store)
+	}
+}
+
+// terrain writes the palette of every terrain key in the world, including
+// keys the rows use that have no cell in the lookup, and then the layers.
+func (e *encoder) terrain(w World) {
+	seen := make(map[uint32]bool)
+	for k := range w.TerrainCellLookup {
+		seen[k] = true
+	}
+	for _, l := range w.TerrainLayers {
+		for _, r := range l.TerrainRows {
+			for _, k := range r.TerrainCellKeys {
+				seen[k] = true
+			}
+		}
+	}
+
+	palette := make([]uint32, 0, len(seen))
+	for k := range seen {
+		palette = append(palette, k)
+	}
+	sort.Slice(palette, func(i, j int) bool { return palette[i] < palette[j] })
+
+	index := make(map[uint32]int, len(palette))
+	e.uint(uint64(len(palette)))
+	for i, k := range palette {
+		index[k] = i
+		e.uint(uint64(k))
+
+		c, ok := w.TerrainCellLookup[k]
+		e.bool(ok)
+		if ok {
+			e.string(c.Symbol)
+			e.color(c.ColorFG)
+			e.color(c.ColorBG)
+			e.string(c.Name)
+			e.string(c.ID)
+			e.string(c.Special)
+		}
+	}
+
+	e.uint(uint64(len(w.TerrainLayers)))
+	for _, l := range w.TerrainLayers {
+		e.bool(l.Empty)
+		e.uint(uint64(len(l.TerrainRows)))
+		for _, r := range l.TerrainRows {
+			e.runs(len(r.TerrainCellKeys), func(i int) int { return index[r.TerrainCellKeys[i]] })
+		}
+	}
+}
+
+func (e *encoder) seenCells(lookup map[bool]SeenCell) {
+	e.uint(uint64(len(lookup)))
+	for _, k := range []bool{false, true} {
+		c, ok := lookup[k]
+		if !ok {
+			continue
+		}
+		e.bool(k)
+		e.string(c.ID)
+		e.string(c.Symbol)
+		e.bool(c.Seen)
+		e.color(c.ColorFG)
+		e.color(c.ColorBG)
+	}
+}
+
+func (e *encoder) seenLayers(layers []SeenLayer) {
+	e.uint(uint64(len(layers)))
+	for _, l := range layers {
+		e.bool(l.Empty)
+		e.uint(uint64(len(l.SeenRows)))
+		for _, r := range l.SeenRows {
+			bits := make([]byte, (len(r.SeenCellKeys)+7)/8)
+			for i, seen := range r.SeenCellKeys {
+				if seen {
+					bits[i/8] |= 1 << uint(i%8)
+				}
+			}
+			e.uint(uint64(len(r.SeenCellKeys)))
+			e.write(bits)
+		}
+		e.uint(uint64(len(l.Corrupt)))
+		for _, c := range l.Corrupt {
+			e.int(c[0])
+			e.int(c[1])
+		}
+	}
+}
+
+func (e *encoder) seenLayerMap(m map[string][]SeenLayer) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	e.uint(uint64(len(m)))
+	for _, k := range sortStrings(keys) {
+		e.string(k)
+		e.seenLayers(m[k])
+	}
+}
+
+func (e *encoder) cityLayer(l CityLayer) {
+	seen := make(map[string]bool)
+	for _, r := range l.CityRows {
+		for _, c := range r.CityCell {
+			seen[c] = true
+		}
+	}
+	palette := make([]string, 0, len(seen))
+	for c := range seen {
+		palette = append(palette, c)
+	}
+	sort.Strings(palette)
+
+	index := make(map[string]int, len(palette))
+	for i, c := range palette {
+		index[c] = i
+	}
+
+	e.strings(palette)
+	e.uint(uint64(len(l.CityRows)))
+	for _, r := range l.CityRows {
+		e.runs(len(r.CityCell), func(i int) int { return index[r.CityCell[i]] })
+	}
+
+	e.uint(uint64(len(l.Cities)))
+	for _, c := range l.Cities {
+		e.string(c.Name)
+		e.int(c.X)
+		e.int(c.Y)
+		e.int(c.Size)
+	}
+}
+
+func (e *encoder) characters(m map[string]Character) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	e.uint(uint64(len(m)))
+	for _, k := range sortStrings(keys) {
+		c := m[k]
+		e.string(k)
+		e.string(c.ID)
+		e.string(c.Name)
+		e.bool(c.Located)
+		e.int(c.OvermapX)
+		e.int(c.OvermapY)
+		e.int(c.Z)
+		e.int(c.Turn)
+		e.int(c.X)
+		e.int(c.Y)
+		e.int(c.Layer)
+	}
+}
+
+func (e *encoder) notes(m map[string][]Note) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	e.uint(uint64(len(m)))
+	for _, k := range sortStrings(keys) {
+		e.string(k)
+		e.uint(uint64(len(m[k])))
+		for _, n := range m[k] {
+			e.int(n.X)
+			e.int(n.Y)
+			e.int(n.OvermapX)
+			e.int(n.OvermapY)
+			e.int(n.Layer)
+			e.int(n.Z)
+			e.string(n.Symbol)
+			e.color(n.Color)
+			e.string(n.ColorName)
+			e.string(n.Text)
+			e.bool(n.Dangerous)
+			e.int(n.DangerRadius)
+		}
+	}
+}
+
+func (e *encoder) combinedSeen(m map[string]CombinedSeen) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	e.uint(uint64(len(m)))
+	for _, k := range sortStrings(keys) {
+		cs := m[k]
+		e.string(k)
+		e.int(int(cs.Mode))
+		e.strings(cs.Characters)
+		e.seenLayers(cs.Layers)
+		e.seenLayers(cs.Explored)
+	}
+}
+
+func (e *encoder) store(s Store) {
+	e.int(s.MaxZ)
+	e.float(s.CellWidth)
+	e.float(s.CellHeight)
+
+	e.uint(uint64(len(s.Layers)))
+	for _, l := range s.Layers {
+		e.int(l.Z)
+		e.string(l.Type)
+		e.string(l.Character)
+		e.string(l.Name)
+		e.string(l.TileRoot)
+	}
+
+	layers := make([]int, 0, len(s.Terrain))
+	for z := range s.Terrain {
+		layers = append(layers, z)
+	}
+	sort.Ints(layers)
+
+	e.uint(uint64(len(layers)))
+	for _, z := range layers {
+		e.int(z)
+		e.terrainIndex(s.Terrain[z])
+	}
+}
+
+// terrainIndex writes the outlines of the regions first and then the region
+// of every cell as runs, shifted by one so cells outside any region are 0.
+func (e *encoder) terrainIndex(i TerrainIndex) {
+	e.uint(uint64(len(i.Outlines)))
+	for _, polygons := range i.Outlines {
+		e.uint(uint64(len(polygons)))
+		for _, rings := range polygons {
+			e.uint(uint64(len(rings)))
+			for _, ring := range rings {
+				e.uint(uint64(len(ring)))
+				for _, p := range ring {
+					e.int(p[0])
+					e.int(p[1])
+				}
+			}
+		}
+	}
+
+	e.uint(uint64(i.Width))
+	e.uint(uint64(i.Height))
+	e.runs(len(i.Regions), func(c int) int { return int(i.Regions[c]) + 1 })
+}
+
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	d.fail(err)
+	return v
+}
+
+// length reads a count or size, which has to be at most maxLength.
+func (d *decoder) length() int {
+	v := d.uint()
+	if v > maxLength {
+		d.fail(fmt.Errorf("length %v is out of range", v))
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) int() int {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	d.fail(err)
+	return int(v)
+}
+
+// read reads a value of a fixed size of n bytes.
+func (d *decoder) read(n int) []byte {
+	b := make([]byte, n)
+	if d.err != nil {
+		return b
+	}
+	_, err := io.ReadFull(d.r, b)
+	d.fail(err)
+	return b
+}
+
+// bytes reads n bytes of a length prefixed value, growing the buffer as they
+// arrive. It returns fewer bytes when the stream ends first.
+func (d *decoder) bytes(n int) []byte {
+	var b bytes.Buffer
+	if d.err != nil {
+		return nil
+	}
+	_, err := io.CopyN(&b, d.r, int64(n))
+	d.fail(err)
+	return b.Bytes()
+}
+
+func (d *decoder) bool() bool {
+	return d.read(1)[0] != 0
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.length()))
+}
+
+func (d *decoder) strings() []string {
+	s := make([]string, 0)
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		s = append(s, d.string())
+	}
+	return s
+}
+
+func (d *decoder) float() float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(d.read(8)))
+}
+
+func (d *decoder) color() color.RGBA {
+	b := d.read(4)
+	return color.RGBA{b[0], b[1], b[2], b[3]}
+}
+
+// runs reads a row of palette indexes written by encoder.runs, calling add
+// for every cell in order.
+func (d *decoder) runs(paletteSize int, add func(index int)) {
+	n := d.length()
+	for i := 0; i < n && d.err == nil; {
+		count := d.length()
+		index := d.length()
+		if count == 0 || i+count > n || index >= paletteSize {
+			d.fail(fmt.Errorf("run of %v cells of palette index %v doesn't fit its row", count, index))
+			break
+		}
+		for j := 0; j < count; j++ {
+			add(index)
+		}
+		i += count
+	}
+}
+
+func (d *decoder) world() World {
+	w := World{}
+	w.Name = d.string()
+	w.OriginX = d.int()
+	w.OriginY = d.int()
+
+	w.TerrainCellLookup, w.TerrainLayers = d.terrain()
+	w.SeenCellLookup = d.seenCells()
+	w.SeenLayers = d.seenLayerMap()
+	w.ExploredLayers = d.seenLayerMap()
+	w.CityLayer = d.cityLayer()
+	w.Characters = d.characters()
+	w.Notes = d.notes()
+	w.CombinedSeen = d.combinedSeen()
+
+	if d.bool() {
+		s := d.store()
+		w.Store = &s
+	}
+	return w
+}
+
+func (d *decoder) terrain() (map[uint32]TerrainCell, []TerrainLayer) {
+	lookup := make(map[uint32]TerrainCell)
+	palette := make([]uint32, 0)
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		k := uint32(d.uint())
+		palette = append(palette, k)
+		if d.bool() {
+			lookup[k] = TerrainCell{
+				Symbol:  d.string(),
+				ColorFG: d.color(),
+				ColorBG: d.color(),
+				Name:    d.string(),
+				ID:      d.string(),
+				Special: d.string(),
+			}
+		}
+	}
+
+	layers := make([]TerrainLayer, 0)
+	n = d.length()
+	for li := 0; li < n && d.err == nil; li++ {
+		l := TerrainLayer{Empty: d.bool(), TerrainRows: make([]TerrainRow, 0)}
+		rows := d.length()
+		for ri := 0; ri < rows && d.err == nil; ri++ {
+			keys := make([]uint32, 0)
+			d.runs(len(palette), func(index int) {
+				keys = append(keys, palette[index])
+			})
+			l.TerrainRows = append(l.TerrainRows, TerrainRow{TerrainCellKeys: keys})
+		}
+		layers = append(layers, l)
+	}
+	return lookup, layers
+}
+
+func (d *decoder) seenCells() map[bool]SeenCell {
+	lookup := make(map[bool]SeenCell)
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		k := d.bool()
+		lookup[k] = SeenCell{
+			ID:      d.string(),
+			Symbol:  d.string(),
+			Seen:    d.bool(),
+			ColorFG: d.color(),
+			ColorBG: d.color(),
+		}
+	}
+	return lookup
+}
+
+func (d *decoder) seenLayers() []SeenLayer {
+	layers := make([]SeenLayer, 0)
+	n := d.length()
+	for li := 0; li < n && d.err == nil; li++ {
+		l := SeenLayer{Empty: d.bool(), SeenRows: make([]SeenRow, 0)}
+		rows := d.length()
+		for ri := 0; ri < rows && d.err == nil; ri++ {
+			cells := d.length()
+			bits := d.bytes((cells + 7) / 8)
+			if d.err != nil {
+				break
+			}
+			keys := make([]bool, cells)
+			for i := range keys {
+				keys[i] = bits[i/8]&(1<<uint(i%8)) != 0
+			}
+			l.SeenRows = append(l.SeenRows, SeenRow{SeenCellKeys: keys})
+		}
+		corrupt := d.length()
+		for i := 0; i < corrupt && d.err == nil; i++ {
+			l.Corrupt = append(l.Corrupt, [2]int{d.int(), d.int()})
+		}
+		layers = append(layers, l)
+	}
+	return layers
+}
+
+func (d *decoder) seenLayerMap() map[string][]SeenLayer {
+	m := make(map[string][]SeenLayer)
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		k := d.string()
+		m[k] = d.seenLayers()
+	}
+	return m
+}
+
+func (d *decoder) cityLayer() CityLayer {
+	palette := d.strings()
+
+	l := CityLayer{CityRows: make([]CityRow, 0), Cities: make([]City, 0)}
+	n := d.length()
+	for ri := 0; ri < n && d.err == nil; ri++ {
+		cells := make([]string, 0)
+		d.runs(len(palette), func(index int) {
+			cells = append(cells, palette[index])
+		})
+		l.CityRows = append(l.CityRows, CityRow{CityCell: cells})
+	}
+
+	n = d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		l.Cities = append(l.Cities, City{
+			Name: d.string(),
+			X:    d.int(),
+			Y:    d.int(),
+			Size: d.int(),
+		})
+	}
+	return l
+}
+
+func (d *decoder) characters() map[string]Character {
+	m := make(map[string]Character)
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		k := d.string()
+		m[k] = Character{
+			ID:       d.string(),
+			Name:     d.string(),
+			Located:  d.bool(),
+			OvermapX: d.int(),
+			OvermapY: d.int(),
+			Z:        d.int(),
+			Turn:     d.int(),
+			X:        d.int(),
+			Y:        d.int(),
+			Layer:    d.int(),
+		}
+	}
+	return m
+}
+
+func (d *decoder) notes() map[string][]Note {
+	m := make(map[string][]Note)
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		k := d.string()
+		notes := make([]Note, 0)
+		count := d.length()
+		for ni := 0; ni < count && d.err == nil; ni++ {
+			notes = append(notes, Note{
+				X:            d.int(),
+				Y:            d.int(),
+				OvermapX:     d.int(),
+				OvermapY:     d.int(),
+				Layer:        d.int(),
+				Z:            d.int(),
+				Symbol:       d.string(),
+				Color:        d.color(),
+				ColorName:    d.string(),
+				Text:         d.string(),
+				Dangerous:    d.bool(),
+				DangerRadius: d.int(),
+			})
+		}
+		m[k] = notes
+	}
+	return m
+}
+
+func (d *decoder) combinedSeen() map[string]CombinedSeen {
+	m := make(map[string]CombinedSeen)
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		k := d.string()
+		m[k] = CombinedSeen{
+			Mode:       CombineMode(d.int()),
+			Characters: d.strings(),
+			Layers:     d.seenLayers(),
+			Explored:   d.seenLayers(),
+		}
+	}
+	return m
+}
+
+func (d *decoder) store() Store {
+	s := Store{
+		MaxZ:       d.int(),
+		CellWidth:  d.float(),
+		CellHeight: d.float(),
+		Layers:     make([]StoreLayer, 0),
+		Terrain:    make(map[int]TerrainIndex),
+	}
+
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		s.Layers = append(s.Layers, StoreLayer{
+			Z:         d.int(),
+			Type:      d.string(),
+			Character: d.string(),
+			Name:      d.string(),
+			TileRoot:  d.string(),
+		})
+	}
+
+	n = d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		z := d.int()
+		s.Terrain[z] = d.terrainIndex()
+	}
+	return s
+}
+
+func (d *decoder) terrainIndex() TerrainIndex {
+	i := TerrainIndex{Outlines: make([][][][][2]int, 0)}
+	n := d.length()
+	for ri := 0; ri < n && d.err == nil; ri++ {
+		polygons := make([][][][2]int, 0)
+		pn := d.length()
+		for pi := 0; pi < pn && d.err == nil; pi++ {
+			rings := make([][][2]int, 0)
+			rn := d.length()
+			for ringIndex := 0; ringIndex < rn && d.err == nil; ringIndex++ {
+				ring := make([][2]int, 0)
+				points := d.length()
+				for vi := 0; vi < points && d.err == nil; vi++ {
+					ring = append(ring, [2]int{d.int(), d.int()})
+				}
+				rings = append(rings, ring)
+			}
+			polygons = append(polygons, rings)
+		}
+		i.Outlines = append(i.Outlines, polygons)
+	}
+
+	i.Width = d.length()
+	i.Height = d.length()
+	i.Regions = make([]int32, 0)
+	d.runs(len(i.Outlines)+1, func(index int) {
+		i.Regions = append(i.Regions, int32(index-1))
+	})
+	if d.err == nil && len(i.Regions) != i.Width*i.Height {
+		d.fail(fmt.Errorf("terrain index of %v cells doesn't fit %v by %v", len(i.Regions), i.Width, i.Height))
+	}
+	return i
+}
+
+func sortStrings(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
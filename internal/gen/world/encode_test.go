@@ -0,0 +1,210 @@
+package world
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testWorld() World {
+	field := TerrainCell{Symbol: ".", ColorFG: color.RGBA{92, 51, 23, 255}, Name: "field", ID: "field"}
+	house := TerrainCell{Symbol: "^", ColorFG: color.RGBA{255, 255, 255, 255}, ColorBG: color.RGBA{0, 0, 0, 255}, Name: "house", ID: "house_north", Special: "house_1"}
+
+	seen := SeenLayer{SeenRows: []SeenRow{
+		{SeenCellKeys: []bool{true, false, true, true, false, false, false, false, true, true}},
+		{SeenCellKeys: []bool{false, false, false, false, false, false, false, false, false, false}},
+	}, Corrupt: [][2]int{{0, 1}, {2, 0}}}
+	unseen := SeenLayer{Empty: true, SeenRows: []SeenRow{}}
+
+	return World{
+		Name: "Lost Haven",
+		TerrainLayers: []TerrainLayer{
+			{Empty: true, TerrainRows: []TerrainRow{}},
+			{TerrainRows: []TerrainRow{
+				{TerrainCellKeys: []uint32{1, 1, 1, 2, 7}},
+				{TerrainCellKeys: []uint32{2, 2, 1, 1, 1}},
+			}},
+		},
+		SeenLayers:        map[string][]SeenLayer{"#SGFucw==": {unseen, seen}},
+		ExploredLayers:    map[string][]SeenLayer{},
+		TerrainCellLookup: map[uint32]TerrainCell{1: field, 2: house, 3: {ID: "unused"}},
+		SeenCellLookup: map[bool]SeenCell{
+			true:  {Symbol: " ", Seen: true},
+			false: {ID: "unseen", Symbol: "#", ColorBG: color.RGBA{44, 44, 44, 255}},
+		},
+		CityLayer: CityLayer{
+			CityRows: []CityRow{
+				{CityCell: []string{"", "Lost Haven", "Lost Haven"}},
+				{CityCell: []string{"", "", ""}},
+			},
+			Cities: []City{{Name: "Lost Haven", X: 1, Y: 0, Size: 2}},
+		},
+		Characters: map[string]Character{
+			"#SGFucw==": {ID: "#SGFucw==", Name: "Hans", Located: true, OvermapX: -3, OvermapY: 4, Turn: 5000, X: 2, Y: 1, Layer: 10},
+		},
+		Notes: map[string][]Note{
+			"#SGFucw==": {{X: 1, Y: 1, OvermapX: -4, OvermapY: 5, Layer: 10, Symbol: "!", Color: color.RGBA{255, 0, 0, 255}, ColorName: "red", Text: "zombies", Dangerous: true, DangerRadius: 3}},
+		},
+		CombinedSeen: map[string]CombinedSeen{
+			"everyone": {Mode: Intersection, Characters: []string{"#SGFucw=="}, Layers: []SeenLayer{unseen, seen}, Explored: []SeenLayer{}},
+		},
+		OriginX: -180,
+		OriginY: 360,
+		Store: &Store{
+			MaxZ:       3,
+			CellWidth:  21.3594,
+			CellHeight: 24,
+			Layers: []StoreLayer{
+				{Z: 1, Type: "overmap", TileRoot: "Lost Haven/o_1_tiles"},
+				{Z: 1, Type: "seen", Character: "#SGFucw==", TileRoot: "Lost Haven/#SGFucw==_visible_1_tiles"},
+			},
+			Terrain: map[int]TerrainIndex{
+				1: {
+					Width:    5,
+					Height:   2,
+					Regions:  []int32{0, 0, 0, 1, -1, 1, 1, 0, 0, 0},
+					Outlines: [][][][][2]int{{{{{0, 0}, {3, 0}, {3, 2}, {0, 2}, {0, 0}}}}, {{{{3, 0}, {4, 0}, {4, 1}, {3, 1}, {3, 0}}}}},
+				},
+			},
+		},
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	w := testWorld()
+
+	data, err := w.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got World
+	err = got.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, w) {
+		t.Errorf("got %+v, want %+v", got, w)
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	data, err := testWorld().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// body deflates a body written by write after a valid header.
+	body := func(write func(b []byte) []byte) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(fileMagic)
+		buf.WriteByte(fileFormat)
+		fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		fw.Write(write(nil))
+		fw.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{
+			name: "empty",
+			data: nil,
+			err:  "EOF",
+		},
+		{
+			name: "not a world file",
+			data: []byte("CDDAMAP\x01rest"),
+			err:  "not a world file",
+		},
+		{
+			name: "newer format",
+			data: append([]byte(fileMagic), fileFormat+1),
+			err:  "world file format",
+		},
+		{
+			name: "truncated",
+			data: data[:len(data)/2],
+			err:  "unexpected EOF",
+		},
+		{
+			name: "missing its last byte",
+			data: data[:len(data)-1],
+			err:  "EOF",
+		},
+		{
+			name: "data after the world",
+			data: body(func(b []byte) []byte {
+				b = binary.AppendUvarint(b, 0)
+				b = binary.AppendVarint(b, 0)
+				b = binary.AppendVarint(b, 0)
+				for i := 0; i < 12; i++ {
+					b = binary.AppendUvarint(b, 0)
+				}
+				return append(b, 0)
+			}),
+			err: "unexpected data after the world",
+		},
+		{
+			name: "length out of range",
+			data: body(func(b []byte) []byte {
+				return binary.AppendUvarint(b, maxLength+1)
+			}),
+			err: "out of range",
+		},
+		{
+			name: "name longer than the file",
+			data: body(func(b []byte) []byte {
+				b = binary.AppendUvarint(b, maxLength)
+				return append(b, "Lost Haven"...)
+			}),
+			err: "unexpected EOF",
+		},
+		{
+			name: "more layers than the file has",
+			data: body(func(b []byte) []byte {
+				b = binary.AppendUvarint(b, 0)
+				b = binary.AppendVarint(b, 0)
+				b = binary.AppendVarint(b, 0)
+				b = binary.AppendUvarint(b, 0)
+				return binary.AppendUvarint(b, maxLength)
+			}),
+			err: "unexpected EOF",
+		},
+		{
+			name: "run past the end of its row",
+			data: body(func(b []byte) []byte {
+				b = binary.AppendUvarint(b, 0)
+				b = binary.AppendVarint(b, 0)
+				b = binary.AppendVarint(b, 0)
+				b = binary.AppendUvarint(b, 1)
+				b = binary.AppendUvarint(b, 1)
+				b = append(b, 0)
+				b = binary.AppendUvarint(b, 1)
+				b = append(b, 0)
+				b = binary.AppendUvarint(b, 1)
+				b = binary.AppendUvarint(b, 2)
+				b = binary.AppendUvarint(b, 3)
+				return binary.AppendUvarint(b, 0)
+			}),
+			err: "doesn't fit its row",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(bytes.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
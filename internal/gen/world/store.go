@@ -0,0 +1,46 @@
+package world
+
+// Store is what cddamapgen --filestore adds to a world so that the server can
+// serve it from its world file without a database: the layers that were
+// rendered for it and an index of its terrain layers.
+type Store struct {
+	MaxZ       int
+	CellWidth  float64
+	CellHeight float64
+	Layers     []StoreLayer
+	// Terrain indexes the terrain layers by layer index.
+	Terrain map[int]TerrainIndex
+}
+
+// StoreLayer is a rendered layer. Z is its layer index, Character the ID of
+// the character a seen or masked layer belongs to, and Name the name of a
+// combined seen layer.
+type StoreLayer struct {
+	Z         int
+	Type      string
+	Character string
+	Name      string
+	TileRoot  string
+}
+
+// TerrainIndex is a spatial grid index of a terrain layer. Every cell points
+// at the region of contiguous equal terrain it is part of, and every region
+// has its outline, so the area under a point is found without scanning the
+// layer.
+type TerrainIndex struct {
+	Width   int
+	Height  int
+	Regions []int32
+	// Outlines are polygons made of an outer ring followed by its holes, with
+	// their points at cell corners.
+	Outlines [][][][][2]int
+}
+
+// Region returns the region of a cell, or -1 when the cell is outside the
+// layer or has no terrain worth a region.
+func (i TerrainIndex) Region(x, y int) int {
+	if x < 0 || y < 0 || x >= i.Width || y >= i.Height {
+		return -1
+	}
+	return int(i.Regions[y*i.Width+x])
+}
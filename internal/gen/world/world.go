@@ -1,11 +1,13 @@
 package world
 
 import (
-	"fmt"
 	"image/color"
 
+	"github.com/ralreegorganon/cddamap/internal/gen/coords"
 	"github.com/ralreegorganon/cddamap/internal/gen/metadata"
+	"github.com/ralreegorganon/cddamap/internal/gen/report"
 	"github.com/ralreegorganon/cddamap/internal/gen/save"
+	log "github.com/sirupsen/logrus"
 )
 
 func keyExists(decoded map[string]interface{}, key string) bool {
@@ -29,13 +31,38 @@ func abs(x int) int {
 	return x
 }
 
+// CorruptTerrainID marks the cells of overmap chunks that couldn't be decoded.
+const CorruptTerrainID = "cddamap_corrupt"
+
+// CorruptCell is how the cells of chunks that couldn't be decoded look, on
+// terrain layers and on seen layers alike.
+var CorruptCell = TerrainCell{
+	ID:      CorruptTerrainID,
+	Symbol:  "!",
+	ColorFG: color.RGBA{255, 255, 255, 255},
+	ColorBG: color.RGBA{200, 0, 0, 255},
+	Name:    "corrupt chunk",
+}
+
 type World struct {
 	Name              string
 	TerrainLayers     []TerrainLayer
 	SeenLayers        map[string][]SeenLayer
+	ExploredLayers    map[string][]SeenLayer
 	TerrainCellLookup map[uint32]TerrainCell
 	SeenCellLookup    map[bool]SeenCell
 	CityLayer         CityLayer
+	Characters        map[string]Character
+	Notes             map[string][]Note
+	CombinedSeen      map[string]CombinedSeen
+
+	// OriginX and OriginY are the absolute overmap terrain coordinates of
+	// the top left cell of every layer.
+	OriginX int
+	OriginY int
+
+	// Store is only set on worlds written for the server.
+	Store *Store
 }
 
 type TerrainLayer struct {
@@ -53,11 +80,17 @@ type TerrainCell struct {
 	ColorBG color.RGBA
 	Name    string
 	ID      string
+	Special string
 }
 
+// SeenLayer is what a character has seen of a layer. Corrupt holds the
+// chunks, as x and y in chunks from the top left of the layer, whose seen
+// file couldn't be decoded: their cells are unseen for lack of data, so they
+// are marked on the map, and a layer with corrupt chunks isn't empty.
 type SeenLayer struct {
 	Empty    bool
 	SeenRows []SeenRow
+	Corrupt  [][2]int
 }
 
 type SeenRow struct {
@@ -88,7 +121,22 @@ type City struct {
 	Size int
 }
 
-func Build(m metadata.Overmap, s save.Save) (World, error) {
+// Character is a player character. X and Y are its cell in the world grid
+// and Layer its layer, which are only meaningful when Located is set.
+type Character struct {
+	ID       string
+	Name     string
+	Located  bool
+	OvermapX int
+	OvermapY int
+	Z        int
+	Turn     int
+	X        int
+	Y        int
+	Layer    int
+}
+
+func Build(m metadata.Overmap, s save.Save, r *report.Report) (World, error) {
 
 	terrainCellLookup := make(map[uint32]TerrainCell)
 
@@ -107,17 +155,28 @@ func Build(m metadata.Overmap, s save.Save) (World, error) {
 		},
 	}
 
-	terrainLayers := buildTerrainLayers(m, s, terrainCellLookup)
-	characterSeenLayers := buildCharacterSeenLayers(m, s)
+	terrainLayers := buildTerrainLayers(m, s, terrainCellLookup, r)
+	characterSeenLayers := buildCharacterSeenLayers(m, s, func(c save.SeenChunk) [][]save.SeenGroup { return c.Visible })
+	characterExploredLayers := buildCharacterSeenLayers(m, s, func(c save.SeenChunk) [][]save.SeenGroup { return c.Explored })
 	cityLayer := buildCityLayer(m, s)
+	characters := buildCharacters(m, s)
+	notes := buildNotes(m, s)
+
+	wcd := calculateWorldChunkDimensions(m, s)
+	origin := coords.Chunk{X: wcd.XMin, Y: wcd.YMin}.Origin()
 
 	world := World{
 		Name:              s.Name,
+		OriginX:           origin.X,
+		OriginY:           origin.Y,
 		TerrainLayers:     terrainLayers,
 		SeenLayers:        characterSeenLayers,
+		ExploredLayers:    characterExploredLayers,
 		TerrainCellLookup: terrainCellLookup,
 		SeenCellLookup:    seenCellLookup,
 		CityLayer:         cityLayer,
+		Characters:        characters,
+		Notes:             notes,
 	}
 
 	return world, nil
@@ -138,21 +197,28 @@ func calculateWorldChunkDimensions(m metadata.Overmap, s save.Save) worldChunkDi
 	cYMax := 0
 	cYMin := 0
 
-	for _, c := range s.Overmap.Chunks {
-		if c.X > cXMax {
-			cXMax = c.X
+	extend := func(x, y int) {
+		if x > cXMax {
+			cXMax = x
 		}
-		if c.Y > cYMax {
-			cYMax = c.Y
+		if y > cYMax {
+			cYMax = y
 		}
-		if c.X < cXMin {
-			cXMin = c.X
+		if x < cXMin {
+			cXMin = x
 		}
-		if c.Y < cYMin {
-			cYMin = c.Y
+		if y < cYMin {
+			cYMin = y
 		}
 	}
 
+	for _, c := range s.Overmap.Chunks {
+		extend(c.X, c.Y)
+	}
+	for _, c := range s.Overmap.Corrupt {
+		extend(c.X, c.Y)
+	}
+
 	cXSize := abs(cXMax) + abs(cXMin) + 1
 	cYSize := abs(cYMax) + abs(cYMin) + 1
 
@@ -216,7 +282,125 @@ func buildCityLayer(m metadata.Overmap, s save.Save) CityLayer {
 	return layer
 }
 
-func buildCharacterSeenLayers(m metadata.Overmap, s save.Save) map[string][]SeenLayer {
+// Note is a player's map note. X and Y are its cell in the world grid.
+// Symbol, Color and Text come from the note text, which the game lets start
+// with "S:" to pick the symbol and "c;" to pick the color.
+type Note struct {
+	X            int
+	Y            int
+	OvermapX     int
+	OvermapY     int
+	Layer        int
+	Z            int
+	Symbol       string
+	Color        color.RGBA
+	ColorName    string
+	Text         string
+	Dangerous    bool
+	DangerRadius int
+}
+
+var noteColors = map[byte]string{
+	'r': "red",
+	'R': "light_red",
+	'g': "green",
+	'G': "light_green",
+	'b': "blue",
+	'B': "light_blue",
+	'W': "white",
+	'C': "cyan",
+	'c': "light_cyan",
+	'P': "pink",
+	'm': "magenta",
+	'Y': "yellow",
+}
+
+func parseNoteText(text string) (string, string, string) {
+	symbol := "N"
+	colorName := "yellow"
+
+	for i := 0; i < 2 && len(text) >= 2; i++ {
+		switch text[1] {
+		case ':':
+			symbol = text[:1]
+		case ';':
+			if c, ok := noteColors[text[0]]; ok {
+				colorName = c
+			}
+		default:
+			return symbol, colorName, text
+		}
+		text = text[2:]
+	}
+
+	return symbol, colorName, text
+}
+
+func buildNotes(m metadata.Overmap, s save.Save) map[string][]Note {
+	wcd := calculateWorldChunkDimensions(m, s)
+
+	notes := make(map[string][]Note)
+	for name, seen := range s.Seen {
+		characterNotes := make([]Note, 0)
+		for _, c := range seen.Chunks {
+			for li, l := range c.Notes {
+				for _, n := range l {
+					symbol, colorName, text := parseNoteText(n.Text)
+					fg, _, _ := metadata.NamedColor(colorName)
+					characterNotes = append(characterNotes, Note{
+						X:            (c.X-wcd.XMin)*180 + n.X,
+						Y:            (c.Y-wcd.YMin)*180 + n.Y,
+						OvermapX:     c.X*180 + n.X,
+						OvermapY:     c.Y*180 + n.Y,
+						Layer:        li,
+						Z:            li - 10,
+						Symbol:       symbol,
+						Color:        fg,
+						ColorName:    colorName,
+						Text:         text,
+						Dangerous:    n.Dangerous,
+						DangerRadius: n.DangerRadius,
+					})
+				}
+			}
+		}
+		notes[name] = characterNotes
+	}
+
+	return notes
+}
+
+func buildCharacters(m metadata.Overmap, s save.Save) map[string]Character {
+	wcd := calculateWorldChunkDimensions(m, s)
+
+	characters := make(map[string]Character)
+	for id, c := range s.Characters {
+		ch := Character{
+			ID:       c.ID,
+			Name:     c.Name,
+			Located:  c.Located,
+			OvermapX: c.OvermapX,
+			OvermapY: c.OvermapY,
+			Z:        c.Z,
+			Turn:     c.Turn,
+			X:        c.OvermapX - wcd.XMin*180,
+			Y:        c.OvermapY - wcd.YMin*180,
+			Layer:    c.Z + 10,
+		}
+
+		if ch.X < 0 || ch.X >= wcd.XSize*180 || ch.Y < 0 || ch.Y >= wcd.YSize*180 || ch.Layer < 0 || ch.Layer > 20 {
+			ch.Located = false
+		}
+
+		characters[id] = ch
+	}
+
+	return characters
+}
+
+// buildCharacterSeenLayers builds a layer set per character from the groups
+// picked out of each seen chunk, either the visible or the explored ones.
+func buildCharacterSeenLayers(m metadata.Overmap, s save.Save, groups func(save.SeenChunk) [][]save.SeenGroup) map[string][]SeenLayer {
 	wcd := calculateWorldChunkDimensions(m, s)
 	chunkCapacity := wcd.XSize * wcd.YSize
 
@@ -228,7 +412,7 @@ func buildCharacterSeenLayers(m metadata.Overmap, s save.Save) map[string][]Seen
 		for _, c := range chunks.Chunks {
 			ci := c.X + (0 - wcd.XMin) + wcd.XSize*(c.Y+0-wcd.YMin)
 			doneChunks[ci] = true
-			for li, l := range c.Visible {
+			for li, l := range groups(c) {
 				lzp := 0
 				for _, e := range l {
 					for i := 0; i < int(e.Count); i++ {
@@ -256,6 +440,19 @@ func buildCharacterSeenLayers(m metadata.Overmap, s save.Save) map[string][]Seen
 			}
 		}
 
+		// a seen file holds every layer of its chunk, so a corrupt one is
+		// missing from all of them
+		for _, c := range chunks.Corrupt {
+			x := c.X - wcd.XMin
+			y := c.Y - wcd.YMin
+			if x < 0 || x >= wcd.XSize || y < 0 || y >= wcd.YSize {
+				continue
+			}
+			for l := range layers {
+				layers[l].Corrupt = append(layers[l].Corrupt, [2]int{x, y})
+			}
+		}
+
 		for li := 0; li < 21; li++ {
 			empty := true
 			for xi := 0; xi < wcd.XSize; xi++ {
@@ -271,7 +468,7 @@ func buildCharacterSeenLayers(m metadata.Overmap, s save.Save) map[string][]Seen
 					}
 				}
 			}
-			layers[li].Empty = empty
+			layers[li].Empty = empty && len(layers[li].Corrupt) == 0
 		}
 		seen[name] = layers
 	}
@@ -279,24 +476,33 @@ func buildCharacterSeenLayers(m metadata.Overmap, s save.Save) map[string][]Seen
 	return seen
 }
 
-func buildTerrainLayers(m metadata.Overmap, s save.Save, tcl map[uint32]TerrainCell) []TerrainLayer {
+func buildTerrainLayers(m metadata.Overmap, s save.Save, tcl map[uint32]TerrainCell, r *report.Report) []TerrainLayer {
 	missingTerrain := make(map[string]int)
 
 	for _, c := range s.Overmap.Chunks {
-		for _, l := range c.Layers {
+		for li, l := range c.Layers {
+			lzp := 0
 			for _, e := range l {
 				if exists := m.Exists(e.OvermapTerrainID); !exists {
-					if _, ok := missingTerrain[e.OvermapTerrainID]; !ok {
-						missingTerrain[e.OvermapTerrainID] = 0
-					}
-					missingTerrain[e.OvermapTerrainID]++
+					missingTerrain[e.OvermapTerrainID] += int(e.Count)
+					r.AddMissingTerrain(e.OvermapTerrainID, int(e.Count), report.Location{
+						ChunkX: c.X,
+						ChunkY: c.Y,
+						Layer:  li,
+						X:      lzp % 180,
+						Y:      lzp / 180,
+					})
 				}
+				lzp += int(e.Count)
 			}
 		}
 	}
 
 	for k, v := range missingTerrain {
-		fmt.Printf("missing terrain: %v x %v\n", k, v)
+		log.WithFields(log.Fields{
+			"id":    k,
+			"cells": v,
+		}).Warn("missing terrain")
 	}
 
 	wcd := calculateWorldChunkDimensions(m, s)
@@ -322,6 +528,7 @@ func buildTerrainLayers(m metadata.Overmap, s save.Save, tcl map[uint32]TerrainC
 						ColorFG: cfg,
 						ColorBG: cbg,
 						Name:    n,
+						Special: m.Special(e.OvermapTerrainID),
 					}
 					tcl[h] = tc
 				}
@@ -335,6 +542,17 @@ func buildTerrainLayers(m metadata.Overmap, s save.Save, tcl map[uint32]TerrainC
 		}
 	}
 
+	corruptHash := save.HashTerrainID(CorruptTerrainID)
+	for _, c := range s.Overmap.Corrupt {
+		tcl[corruptHash] = CorruptCell
+
+		ci := c.X + (0 - wcd.XMin) + wcd.XSize*(c.Y+0-wcd.YMin)
+		doneChunks[ci] = true
+		for e := 0; e < 680400; e++ {
+			cells[ci*680400+e] = corruptHash
+		}
+	}
+
 	dfg, dbg := m.Color("default")
 	tc := TerrainCell{
 		ID:      "",
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/guregu/null"
 	"github.com/jmoiron/sqlx"
+	"github.com/ralreegorganon/cddamap/internal/gen/coords"
 )
 
 type DB struct {
@@ -49,6 +52,7 @@ func (db *DB) GetWorldInfo(worldID int) (WorldInfo, error) {
 			l.z,
 			l.type,
 			c.name character_name,
+			l.name layer_name,
 			w.name world_name
 		from 
 			world w
@@ -67,6 +71,12 @@ func (db *DB) GetWorldInfo(worldID int) (WorldInfo, error) {
 		return worldInfo, err
 	}
 
+	characters, err := db.GetCharacters(worldID)
+	if err != nil {
+		return worldInfo, err
+	}
+	worldInfo.Characters = characters
+
 	worldInfo.ID = worldLayerInfos[0].WorldID
 	worldInfo.Name = worldLayerInfos[0].WorldName
 	worldInfo.MaxZ = worldLayerInfos[0].MaxZ
@@ -74,31 +84,483 @@ func (db *DB) GetWorldInfo(worldID int) (WorldInfo, error) {
 	for _, wli := range worldLayerInfos {
 		z, ok := worldInfo.Z[wli.Z]
 		if !ok {
-			z = &ZLevel{
-				SeenLayer:      make(map[string]int),
-				SeenSolidLayer: make(map[string]int),
-			}
+			z = newZLevel()
 			worldInfo.Z[wli.Z] = z
 		}
-
-		switch wli.Type {
-		case "overmap":
-			z.TerrainLayer = null.IntFrom(int64(wli.LayerID))
-			break
-		case "seen":
-			z.SeenLayer[wli.CharacterName.String] = wli.LayerID
-			break
-		case "seen_solid":
-			z.SeenSolidLayer[wli.CharacterName.String] = wli.LayerID
-			break
-		}
+		z.addLayer(wli.Type, wli.CharacterName, wli.LayerName, wli.LayerID)
 	}
 
 	return worldInfo, nil
 }
 
-func (db *DB) GetCellJson(layerID int, x, y float64) ([]byte, error) {
-	sql := fmt.Sprintf(`
+func (db *DB) GetCharacters(worldID int) ([]Character, error) {
+	characters := []Character{}
+	err := db.Select(&characters, `
+		select
+			character_id,
+			name,
+			located,
+			overmap_x,
+			overmap_y,
+			z,
+			turn,
+			st_x(snapshot_to_pixel(the_geom, latest_snapshot(world_id))) x,
+			st_y(snapshot_to_pixel(the_geom, latest_snapshot(world_id))) y
+		from
+			character
+		where
+			world_id = $1
+		order by
+			name
+	`, worldID)
+	if err != nil {
+		return nil, err
+	}
+	return characters, nil
+}
+
+func (db *DB) GetNotesJson(worldID, characterID int, z null.Int) ([]byte, error) {
+	var json []byte
+	err := db.QueryRow(`
+		select
+			row_to_json(fc) geojson
+		from
+			(
+				select
+					'FeatureCollection' as type,
+					coalesce(array_to_json(array_agg(f)), '[]') as features
+				from
+				(
+					select
+						'Feature' as type,
+						st_asgeojson(snapshot_to_pixel(n.the_geom, latest_snapshot(c.world_id)))::json as geometry,
+						json_build_object(
+							'id', n.note_id,
+							'z', n.z,
+							'overmapX', n.overmap_x,
+							'overmapY', n.overmap_y,
+							'symbol', n.symbol,
+							'color', n.color,
+							'text', n.text,
+							'dangerous', n.dangerous,
+							'dangerRadius', n.danger_radius,
+							'danger', st_asgeojson(snapshot_to_pixel(n.danger_geom, latest_snapshot(c.world_id)))::json
+						) as properties
+					from
+						note n
+						inner join character c
+							on n.character_id = c.character_id
+					where
+						c.world_id = $1
+						and c.character_id = $2
+						and ($3::int is null or n.z = $3)
+				) as f
+			) as fc
+		`, worldID, characterID, z).Scan(&json)
+	if err != nil {
+		return nil, err
+	}
+	return json, nil
+}
+
+func (db *DB) GetSnapshots(worldID int) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+	err := db.Select(&snapshots, `
+		select
+			snapshot_id,
+			turn,
+			created_at
+		from
+			snapshot
+		where
+			world_id = $1
+		order by
+			snapshot_id desc
+	`, worldID)
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// GetDiffJson returns what changed in a world between two snapshots as
+// GeoJSON, taking each layer, seen area and city list as of each snapshot.
+// Features of kind terrain are areas of overmap terrain that differ, with a
+// null id on the side of the snapshot that didn't cover them. Features of kind
+// explored are what each character saw for the first time, and cityAdded and
+// cityRemoved are cities that only one of the snapshots has, matched by name
+// and position. Everything is lined up by moving it into the grid of the newer
+// snapshot, as the world grows when new overmaps are generated. z is a game
+// z-level, and cities are on z-level 0.
+func (db *DB) GetDiffJson(worldID, fromSnapshotID, toSnapshotID int, z null.Int) ([]byte, error) {
+	var json []byte
+	err := db.QueryRow(`
+		with
+			layers as (
+				select
+					layer_id,
+					z
+				from
+					layer
+				where
+					world_id = $1
+					and type = 'overmap'
+					and ($4::int is null or z = $4 + 10)
+			),
+			from_snapshot as (
+				select
+					ls.layer_id,
+					max(ls.snapshot_id) snapshot_id
+				from
+					layer_snapshot ls
+					inner join layers l
+						on l.layer_id = ls.layer_id
+				where
+					ls.snapshot_id <= $2
+				group by
+					ls.layer_id
+			),
+			to_snapshot as (
+				select
+					ls.layer_id,
+					max(ls.snapshot_id) snapshot_id
+				from
+					layer_snapshot ls
+					inner join layers l
+						on l.layer_id = ls.layer_id
+				where
+					ls.snapshot_id <= $3
+				group by
+					ls.layer_id
+			),
+			to_grid as (
+				select
+					coalesce(origin_x, 0) origin_x,
+					coalesce(origin_y, 0) origin_y
+				from
+					snapshot
+				where
+					snapshot_id = $3
+			),
+			from_cell as (
+				select
+					c.layer_id, c.id, c.name,
+					st_snaptogrid(st_translate(
+						snapshot_to_pixel(c.the_geom, c.snapshot_id),
+						(coalesce(sn.origin_x, 0) - g.origin_x) * coalesce(sn.cell_width, 0),
+						(coalesce(sn.origin_y, 0) - g.origin_y) * coalesce(sn.cell_height, 0)
+					), 0.01) the_geom
+				from
+					cell c
+					inner join from_snapshot s
+						on s.layer_id = c.layer_id
+						and s.snapshot_id = c.snapshot_id
+					inner join snapshot sn
+						on sn.snapshot_id = c.snapshot_id
+					cross join to_grid g
+			),
+			to_cell as (
+				select
+					c.layer_id, c.id, c.name,
+					st_snaptogrid(st_translate(
+						snapshot_to_pixel(c.the_geom, c.snapshot_id),
+						(coalesce(sn.origin_x, 0) - g.origin_x) * coalesce(sn.cell_width, 0),
+						(coalesce(sn.origin_y, 0) - g.origin_y) * coalesce(sn.cell_height, 0)
+					), 0.01) the_geom
+				from
+					cell c
+					inner join to_snapshot s
+						on s.layer_id = c.layer_id
+						and s.snapshot_id = c.snapshot_id
+					inner join snapshot sn
+						on sn.snapshot_id = c.snapshot_id
+					cross join to_grid g
+			),
+			overlay as (
+				select
+					t.layer_id,
+					f.id from_id,
+					f.name from_name,
+					t.id to_id,
+					t.name to_name,
+					st_intersection(f.the_geom, t.the_geom) the_geom
+				from
+					from_cell f
+					inner join to_cell t
+						on f.layer_id = t.layer_id
+						and f.the_geom && t.the_geom
+						and st_relate(f.the_geom, t.the_geom, '2********')
+				where
+					f.id is distinct from t.id
+				union all
+				select
+					f.layer_id,
+					f.id,
+					f.name,
+					null,
+					null,
+					st_difference(f.the_geom, coalesce((
+						select
+							st_union(t.the_geom)
+						from
+							to_cell t
+						where
+							t.layer_id = f.layer_id
+							and t.the_geom && f.the_geom
+					), 'GEOMETRYCOLLECTION EMPTY'::geometry))
+				from
+					from_cell f
+				union all
+				select
+					t.layer_id,
+					null,
+					null,
+					t.id,
+					t.name,
+					st_difference(t.the_geom, coalesce((
+						select
+							st_union(f.the_geom)
+						from
+							from_cell f
+						where
+							f.layer_id = t.layer_id
+							and f.the_geom && t.the_geom
+					), 'GEOMETRYCOLLECTION EMPTY'::geometry))
+				from
+					to_cell t
+			),
+			changed as (
+				select
+					layer_id,
+					from_id,
+					from_name,
+					to_id,
+					to_name,
+					st_collectionextract(the_geom, 3) the_geom
+				from
+					overlay
+				where
+					st_area(the_geom) > 1
+			),
+			from_seen as (
+				select
+					se.character_id,
+					se.z,
+					st_snaptogrid(st_translate(
+						snapshot_to_pixel(se.the_geom, se.snapshot_id),
+						(coalesce(sn.origin_x, 0) - g.origin_x) * coalesce(sn.cell_width, 0),
+						(coalesce(sn.origin_y, 0) - g.origin_y) * coalesce(sn.cell_height, 0)
+					), 0.01) the_geom
+				from
+					seen se
+					inner join character ch
+						on ch.character_id = se.character_id
+					inner join snapshot sn
+						on sn.snapshot_id = se.snapshot_id
+					cross join to_grid g
+				where
+					ch.world_id = $1
+					and ($4::int is null or se.z = $4 + 10)
+					and se.snapshot_id = seen_snapshot(se.character_id, se.z, $2)
+			),
+			to_seen as (
+				select
+					se.character_id,
+					se.z,
+					st_snaptogrid(st_translate(
+						snapshot_to_pixel(se.the_geom, se.snapshot_id),
+						(coalesce(sn.origin_x, 0) - g.origin_x) * coalesce(sn.cell_width, 0),
+						(coalesce(sn.origin_y, 0) - g.origin_y) * coalesce(sn.cell_height, 0)
+					), 0.01) the_geom
+				from
+					seen se
+					inner join character ch
+						on ch.character_id = se.character_id
+					inner join snapshot sn
+						on sn.snapshot_id = se.snapshot_id
+					cross join to_grid g
+				where
+					ch.world_id = $1
+					and ($4::int is null or se.z = $4 + 10)
+					and se.snapshot_id = seen_snapshot(se.character_id, se.z, $3)
+			),
+			explored as (
+				select
+					t.character_id,
+					t.z,
+					st_collectionextract(st_difference(t.the_geom, coalesce(f.the_geom, 'GEOMETRYCOLLECTION EMPTY'::geometry)), 3) the_geom
+				from
+					to_seen t
+					left join from_seen f
+						on f.character_id = t.character_id
+						and f.z = t.z
+			),
+			from_city as (
+				select
+					ci.name,
+					ci.size,
+					ci.overmap_x,
+					ci.overmap_y,
+					st_translate(
+						snapshot_to_pixel(ci.the_geom, ci.snapshot_id),
+						(coalesce(sn.origin_x, 0) - g.origin_x) * coalesce(sn.cell_width, 0),
+						(coalesce(sn.origin_y, 0) - g.origin_y) * coalesce(sn.cell_height, 0)
+					) the_geom
+				from
+					city ci
+					inner join snapshot sn
+						on sn.snapshot_id = ci.snapshot_id
+					cross join to_grid g
+				where
+					ci.snapshot_id = city_snapshot($1, $2)
+					and ($4::int is null or $4 = 0)
+			),
+			to_city as (
+				select
+					ci.name,
+					ci.size,
+					ci.overmap_x,
+					ci.overmap_y,
+					st_translate(
+						snapshot_to_pixel(ci.the_geom, ci.snapshot_id),
+						(coalesce(sn.origin_x, 0) - g.origin_x) * coalesce(sn.cell_width, 0),
+						(coalesce(sn.origin_y, 0) - g.origin_y) * coalesce(sn.cell_height, 0)
+					) the_geom
+				from
+					city ci
+					inner join snapshot sn
+						on sn.snapshot_id = ci.snapshot_id
+					cross join to_grid g
+				where
+					ci.snapshot_id = city_snapshot($1, $3)
+					and ($4::int is null or $4 = 0)
+			),
+			city_changes as (
+				select
+					'cityAdded' kind,
+					t.*
+				from
+					to_city t
+				where
+					not exists (
+						select
+							1
+						from
+							from_city f
+						where
+							f.name = t.name
+							and f.overmap_x is not distinct from t.overmap_x
+							and f.overmap_y is not distinct from t.overmap_y
+					)
+				union all
+				select
+					'cityRemoved' kind,
+					f.*
+				from
+					from_city f
+				where
+					not exists (
+						select
+							1
+						from
+							to_city t
+						where
+							t.name = f.name
+							and t.overmap_x is not distinct from f.overmap_x
+							and t.overmap_y is not distinct from f.overmap_y
+					)
+			)
+		select
+			row_to_json(fc) geojson
+		from
+			(
+				select
+					'FeatureCollection' as type,
+					coalesce(array_to_json(array_agg(f)), '[]') as features
+				from
+				(
+					select
+						'Feature' as type,
+						st_asgeojson(c.the_geom)::json as geometry,
+						json_build_object(
+							'kind', 'terrain',
+							'z', l.z - 10,
+							'fromId', c.from_id,
+							'fromName', c.from_name,
+							'toId', c.to_id,
+							'toName', c.to_name
+						) as properties
+					from
+						changed c
+						inner join layers l
+							on l.layer_id = c.layer_id
+					union all
+					select
+						'Feature' as type,
+						st_asgeojson(e.the_geom)::json as geometry,
+						json_build_object(
+							'kind', 'explored',
+							'z', e.z - 10,
+							'characterId', e.character_id,
+							'name', ch.name
+						) as properties
+					from
+						explored e
+						inner join character ch
+							on ch.character_id = e.character_id
+					where
+						st_area(e.the_geom) > 1
+					union all
+					select
+						'Feature' as type,
+						st_asgeojson(c.the_geom)::json as geometry,
+						json_build_object(
+							'kind', c.kind,
+							'z', 0,
+							'name', c.name,
+							'size', c.size,
+							'overmapX', c.overmap_x,
+							'overmapY', c.overmap_y
+						) as properties
+					from
+						city_changes c
+				) as f
+			) as fc
+		`, worldID, fromSnapshotID, toSnapshotID, z).Scan(&json)
+	if err != nil {
+		return nil, err
+	}
+	return json, nil
+}
+
+// GetCellJson returns the layer's cells under a point as GeoJSON, as of the
+// given snapshot or as of the latest snapshot when it is null. Along with the
+// terrain, each feature says where the point is in the game: the overmap
+// special it is part of, if any, its overmap terrain and chunk, from the
+// snapshot's grid, and the nearest city with the distance to it in overmap
+// terrains. With a character, only a cell the character has seen is returned.
+func (db *DB) GetCellJson(layerID int, x, y float64, snapshotID, characterID null.Int) ([]byte, error) {
+	var json []byte
+	err := db.QueryRow(`
+		with
+			s as (
+				select
+					snapshot_id,
+					origin_x + floor($3 / cell_width)::int as overmap_x,
+					origin_y + floor($4 / cell_height)::int as overmap_y
+				from
+					snapshot
+				where
+					snapshot_id = (
+						select
+							max(snapshot_id)
+						from
+							layer_snapshot
+						where
+							layer_id = $1
+							and ($2::int is null or snapshot_id <= $2)
+					)
+			)
 		select
 			row_to_json(fc) geojson
 		from
@@ -110,33 +572,467 @@ func (db *DB) GetCellJson(layerID int, x, y float64) ([]byte, error) {
 				(
 					select
 						'Feature' as type,
-						st_asgeojson(the_geom)::json as geometry,
+						st_asgeojson(snapshot_to_pixel(c.the_geom, c.snapshot_id))::json as geometry,
 						json_build_object(
-							'id', id, 
-							'name', name
+							'id', c.id,
+							'name', c.name,
+							'symbol', c.symbol,
+							'colorFg', c.color_fg,
+							'colorBg', c.color_bg,
+							'special', (
+								select
+									b.special
+								from
+									building b
+								where
+									b.layer_id = c.layer_id
+									and b.snapshot_id = c.snapshot_id
+									and st_coveredby(snapshot_pixel_point($3, $4, b.snapshot_id), b.the_geom)
+								limit 1
+							),
+							'overmapX', s.overmap_x,
+							'overmapY', s.overmap_y,
+							'z', c.z - 10,
+							'chunkX', floor(s.overmap_x / 180.0)::int,
+							'chunkY', floor(s.overmap_y / 180.0)::int,
+							'city', (
+								select
+									json_build_object(
+										'name', ci.name,
+										'size', ci.size,
+										'overmapX', ci.overmap_x,
+										'overmapY', ci.overmap_y,
+										'distance', sqrt(power(ci.overmap_x - s.overmap_x, 2) + power(ci.overmap_y - s.overmap_y, 2)),
+										'inCity', greatest(abs(ci.overmap_x - s.overmap_x), abs(ci.overmap_y - s.overmap_y)) <= ci.size
+									)
+								from
+									city ci
+								where
+									ci.snapshot_id = city_snapshot(c.world_id, s.snapshot_id)
+									and ci.overmap_x is not null
+								order by
+									power(ci.overmap_x - s.overmap_x, 2) + power(ci.overmap_y - s.overmap_y, 2)
+								limit 1
+							)
 						) as properties
 					from
-						v_cell
-					where 
-						layer_id = $1
-						and ST_CoveredBy(ST_GeomFromText('POINT(%[1]f %[2]f)'), the_geom)
+						v_cell c
+						inner join s
+							on s.snapshot_id = c.snapshot_id
+					where
+						c.layer_id = $1
+						and st_coveredby(snapshot_pixel_point($3, $4, c.snapshot_id), c.the_geom)
+						and ($5::int is null or exists (
+							select
+								1
+							from
+								seen se
+							where
+								se.character_id = $5
+								and se.z = c.z
+								and se.snapshot_id = seen_snapshot($5, c.z, c.snapshot_id)
+								and st_covers(se.the_geom, snapshot_translate(snapshot_pixel_point($3, $4, c.snapshot_id), c.snapshot_id, se.snapshot_id))
+						))
 				) as f
 			) as fc
-		`, x, y)
+		`, layerID, snapshotID, x, y, characterID).Scan(&json)
+	if err != nil {
+		return nil, err
+	}
+	return json, nil
+}
 
+// GetCellsInBBoxJson returns the layer's cells that intersect a bounding box
+// as GeoJSON, one feature per terrain ID with the cells' geometries clipped to
+// the bounding box and merged, and cells counting what's left inside it. The
+// terrains covering the most cells come first, and the collection's
+// truncated member says whether more than the limit were found. Below the
+// world's native zoom, geometry is simplified to about half a screen pixel.
+// With a character, cells are clipped to what the character has seen.
+func (db *DB) GetCellsInBBoxJson(layerID int, q CellQuery) ([]byte, error) {
 	var json []byte
-	err := db.QueryRow(sql, layerID).Scan(&json)
+	err := db.QueryRow(`
+		with
+			layer_world as (
+				select
+					w.maxz
+				from
+					layer l
+					inner join world w
+						on w.world_id = l.world_id
+				where
+					l.layer_id = $1
+			),
+			snap as (
+				select
+					sn.snapshot_id,
+					sn.cell_width * sn.cell_height * abs(sn.scale_x * sn.scale_y) as cell_area,
+					snapshot_pixel_envelope($3, $4, $5, $6, sn.snapshot_id) as envelope
+				from
+					snapshot sn
+				where
+					sn.snapshot_id = (
+						select
+							max(snapshot_id)
+						from
+							layer_snapshot
+						where
+							layer_id = $1
+							and ($2::int is null or snapshot_id <= $2)
+					)
+			),
+			seen_area as (
+				select
+					snapshot_translate(se.the_geom, se.snapshot_id, s.snapshot_id) the_geom
+				from
+					seen se
+					inner join layer l
+						on l.z = se.z
+					cross join snap s
+				where
+					l.layer_id = $1
+					and se.character_id = $10
+					and se.snapshot_id = seen_snapshot($10, l.z, s.snapshot_id)
+			),
+			merged as (
+				select
+					c.id,
+					min(c.name) as name,
+					min(c.symbol) as symbol,
+					min(c.color_fg) as color_fg,
+					min(c.color_bg) as color_bg,
+					sum(c.cells) as cells,
+					min(s.snapshot_id) as snapshot_id,
+					min(s.cell_area) as cell_area,
+					case
+						when $10::int is null then st_union(st_collectionextract(st_intersection(c.the_geom, s.envelope), 3))
+						else st_intersection(st_union(st_collectionextract(st_intersection(c.the_geom, s.envelope), 3)), (select the_geom from seen_area))
+					end as the_geom
+				from
+					v_cell c
+					inner join snap s
+						on s.snapshot_id = c.snapshot_id
+				where
+					c.layer_id = $1
+					and c.the_geom && s.envelope
+					and ($7::text is null or c.id = $7)
+					and ($10::int is null or st_relate((select the_geom from seen_area), c.the_geom, '2********'))
+				group by
+					c.id
+				order by
+					sum(c.cells) desc,
+					c.id
+				limit $9 + 1
+			),
+			terrain as (
+				select
+					m.id,
+					m.name,
+					m.symbol,
+					m.color_fg,
+					m.color_bg,
+					coalesce(round(st_area(m.the_geom) / m.cell_area)::int, m.cells) as cells,
+					snapshot_to_pixel(m.the_geom, m.snapshot_id) as the_geom
+				from
+					merged m
+			),
+			ranked as (
+				select
+					t.*,
+					row_number() over (order by t.cells desc, t.id) rn
+				from
+					terrain t
+			)
+		select
+			json_build_object(
+				'type', 'FeatureCollection',
+				'features', coalesce((
+					select
+						json_agg(json_build_object(
+							'type', 'Feature',
+							'geometry', st_asgeojson(
+								case
+									when $8::int is null or $8 >= lw.maxz then r.the_geom
+									else st_simplifypreservetopology(r.the_geom, power(2, lw.maxz - $8) / 2.0)
+								end
+							)::json,
+							'properties', json_build_object(
+								'id', r.id,
+								'name', r.name,
+								'symbol', r.symbol,
+								'colorFg', r.color_fg,
+								'colorBg', r.color_bg,
+								'cells', r.cells
+							)
+						) order by r.rn)
+					from
+						ranked r
+						cross join layer_world lw
+					where
+						r.rn <= $9
+				), '[]'::json),
+				'truncated', (select count(*) from ranked) > $9
+			) geojson
+		`, layerID, q.SnapshotID, q.BBox[0], q.BBox[1], q.BBox[2], q.BBox[3], q.TerrainID, q.Zoom, q.Limit, q.CharacterID).Scan(&json)
 	if err != nil {
 		return nil, err
 	}
 	return json, nil
 }
 
-func (db *DB) GetTileRoot(layerID int) (string, error) {
+// GetVisibility returns how much of a bounding box, in pixels, a character
+// of the world has seen on a layer, given by its index. A point is a bounding
+// box with no area, which is either seen or not.
+func (db *DB) GetVisibility(worldID, characterID, layer int, bbox []float64) (Visibility, error) {
+	v := Visibility{}
+	err := db.Get(&v, `
+		with
+			se as (
+				select
+					s.snapshot_id,
+					s.the_geom
+				from
+					seen s
+					inner join character c
+						on c.character_id = s.character_id
+				where
+					c.world_id = $1
+					and s.character_id = $2
+					and s.z = $3
+					and s.snapshot_id = seen_snapshot($2, $3, null)
+			),
+			b as (
+				select
+					snapshot_translate(
+						snapshot_pixel_envelope($4, $5, $6, $7, latest_snapshot($1)),
+						latest_snapshot($1),
+						coalesce((select snapshot_id from se), latest_snapshot($1))
+					) as the_geom
+			)
+		select
+			coalesce(st_intersects(se.the_geom, b.the_geom), false) as seen,
+			case
+				when se.the_geom is null then 0
+				when st_area(b.the_geom) = 0 then st_intersects(se.the_geom, b.the_geom)::int
+				else st_area(st_intersection(se.the_geom, b.the_geom)) / st_area(b.the_geom)
+			end as coverage
+		from
+			b
+			left join se
+				on true
+	`, worldID, characterID, layer, bbox[0], bbox[1], bbox[2], bbox[3])
+	if err != nil {
+		return Visibility{}, err
+	}
+	return v, nil
+}
+
+// GetGrid returns the grid the world was rendered in as of the given snapshot,
+// or as of the latest snapshot when it is null.
+func (db *DB) GetGrid(worldID int, snapshotID null.Int) (coords.Grid, error) {
+	var originX, originY null.Int
+	var cellWidth, cellHeight null.Float
+	err := db.QueryRow(`
+		select
+			origin_x,
+			origin_y,
+			cell_width,
+			cell_height
+		from
+			snapshot
+		where
+			world_id = $1
+			and ($2::int is null or snapshot_id <= $2)
+		order by
+			snapshot_id desc
+		limit 1
+	`, worldID, snapshotID).Scan(&originX, &originY, &cellWidth, &cellHeight)
+	if err != nil {
+		return coords.Grid{}, err
+	}
+	if !originX.Valid || !originY.Valid || !cellWidth.Valid || !cellHeight.Valid {
+		return coords.Grid{}, fmt.Errorf("snapshot of world %v was imported without its grid", worldID)
+	}
+
+	g := coords.Grid{
+		OriginX:    int(originX.Int64),
+		OriginY:    int(originY.Int64),
+		CellWidth:  cellWidth.Float64,
+		CellHeight: cellHeight.Float64,
+	}
+	return g, nil
+}
+
+// GetTileRoot returns where the layer's tiles are as of the given snapshot, or
+// as of the latest snapshot when it is null. Tiles that weren't written to a
+// folder of their snapshot's own have been overwritten by any later import of
+// the layer, so asking for them fails with errNoSnapshotTiles.
+func (db *DB) GetTileRoot(layerID int, snapshotID null.Int) (string, error) {
 	var tileRoot string
-	err := db.QueryRow("select tile_root from v_tile where layer_id = $1", layerID).Scan(&tileRoot)
+	var current bool
+	err := db.QueryRow(`
+		select 
+			tile_root,
+			snapshot_tiles or snapshot_id = (select max(snapshot_id) from layer_snapshot where layer_id = $1) current
+		from 
+			layer_snapshot 
+		where 
+			layer_id = $1 
+			and ($2::int is null or snapshot_id <= $2)
+		order by 
+			snapshot_id desc
+		limit 1
+	`, layerID, snapshotID).Scan(&tileRoot, &current)
+	if err == sql.ErrNoRows && !snapshotID.Valid {
+		err = db.QueryRow("select tile_root from v_tile where layer_id = $1", layerID).Scan(&tileRoot)
+		current = true
+	}
 	if err != nil {
 		return "", err
 	}
+	if !current {
+		return "", errNoSnapshotTiles
+	}
 	return tileRoot, nil
 }
+
+// SearchJson returns the world's terrain cells, as of each layer's latest
+// snapshot, and cities, as of the latest snapshot with cities, whose name or
+// terrain ID contains the query text as GeoJSON. Results are on the game's
+// z-level, with cities on the surface. The collection has a nextOffset member
+// when there are more results.
+func (db *DB) SearchJson(worldID int, q SearchQuery) ([]byte, error) {
+	pattern := "%" + likeEscaper.Replace(q.Text) + "%"
+
+	var minX, minY, maxX, maxY null.Float
+	if len(q.BBox) == 4 {
+		minX = null.FloatFrom(q.BBox[0])
+		minY = null.FloatFrom(q.BBox[1])
+		maxX = null.FloatFrom(q.BBox[2])
+		maxY = null.FloatFrom(q.BBox[3])
+	}
+
+	var json []byte
+	err := db.QueryRow(`
+		with
+			latest as (
+				select
+					ls.layer_id,
+					l.z,
+					max(ls.snapshot_id) snapshot_id
+				from
+					layer_snapshot ls
+					inner join layer l
+						on l.layer_id = ls.layer_id
+				where
+					l.world_id = $1
+					and l.type = 'overmap'
+					and ($3::int is null or l.z = $3 + 10)
+				group by
+					ls.layer_id,
+					l.z
+			),
+			results as (
+				select
+					'cell' kind,
+					c.cell_id result_id,
+					s.z - 10 z,
+					s.layer_id,
+					c.id terrain_id,
+					c.name,
+					null::int size,
+					snapshot_to_pixel(c.the_geom, c.snapshot_id) the_geom
+				from
+					cell c
+					inner join latest s
+						on s.layer_id = c.layer_id
+						and s.snapshot_id = c.snapshot_id
+				where
+					(c.name ilike $2 or c.id ilike $2)
+					and ($4::float8 is null or c.the_geom && snapshot_pixel_envelope($4, $5, $6, $7, c.snapshot_id))
+					and ($8::int is null or exists (
+						select
+							1
+						from
+							seen se
+						where
+							se.character_id = $8
+							and se.z = s.z
+							and se.snapshot_id = seen_snapshot($8, s.z, s.snapshot_id)
+							and st_relate(snapshot_translate(se.the_geom, se.snapshot_id, c.snapshot_id), c.the_geom, '2********')
+					))
+				union all
+				select
+					'city' kind,
+					ci.city_id result_id,
+					0 z,
+					null::int layer_id,
+					null terrain_id,
+					ci.name,
+					ci.size,
+					snapshot_to_pixel(ci.the_geom, ci.snapshot_id) the_geom
+				from
+					city ci
+				where
+					ci.snapshot_id = city_snapshot($1, null)
+					and ci.name ilike $2
+					and ($3::int is null or $3 = 0)
+					and ($4::float8 is null or ci.the_geom && snapshot_pixel_envelope($4, $5, $6, $7, ci.snapshot_id))
+					and ($8::int is null or exists (
+						select
+							1
+						from
+							seen se
+						where
+							se.character_id = $8
+							and se.z = 10
+							and se.snapshot_id = seen_snapshot($8, 10, ci.snapshot_id)
+							and st_intersects(snapshot_translate(se.the_geom, se.snapshot_id, ci.snapshot_id), ci.the_geom)
+					))
+			),
+			page as (
+				select
+					r.*,
+					row_number() over (order by r.kind desc, r.z, r.result_id) rn
+				from
+					results r
+				order by
+					r.kind desc,
+					r.z,
+					r.result_id
+				limit $9 + 1
+				offset $10
+			)
+		select
+			json_build_object(
+				'type', 'FeatureCollection',
+				'features', coalesce((
+					select
+						json_agg(json_build_object(
+							'type', 'Feature',
+							'geometry', st_asgeojson(p.the_geom)::json,
+							'properties', json_build_object(
+								'kind', p.kind,
+								'id', p.result_id,
+								'z', p.z,
+								'layerId', p.layer_id,
+								'terrainId', p.terrain_id,
+								'name', p.name,
+								'size', p.size
+							)
+						) order by p.rn)
+					from
+						page p
+					where
+						p.rn <= $10 + $9
+				), '[]'::json),
+				'nextOffset', case when (select count(*) from page) > $9 then $10 + $9 end
+			) geojson
+		`, worldID, pattern, q.Z, minX, minY, maxX, maxY, q.CharacterID, q.Limit, q.Offset).Scan(&json)
+	if err != nil {
+		return nil, err
+	}
+	return json, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
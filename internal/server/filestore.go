@@ -0,0 +1,374 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"path/filepath"
+	"sort"
+
+	"github.com/guregu/null"
+	"github.com/ralreegorganon/cddamap/internal/gen/coords"
+	"github.com/ralreegorganon/cddamap/internal/gen/world"
+	log "github.com/sirupsen/logrus"
+)
+
+// FileStore serves the worlds written by cddamapgen --filestore, for running
+// without a database. It only knows each world's latest build. IDs are handed
+// out in name order when the stores are opened, so they only last until the
+// server restarts.
+type FileStore struct {
+	worlds []*fileWorld
+	layers map[int]fileLayer
+}
+
+type fileWorld struct {
+	id         int
+	world      world.World
+	store      world.Store
+	characters []Character
+	// characterIDs maps the save IDs of the world's characters to the IDs
+	// they're served under.
+	characterIDs map[string]int
+}
+
+type fileLayer struct {
+	world *fileWorld
+	layer world.StoreLayer
+}
+
+// OpenFileStore reads the world files of the world folders in root, which
+// have to have been written with a store.
+func OpenFileStore(root string) (*FileStore, error) {
+	paths, err := filepath.Glob(filepath.Join(root, "*", world.FileName))
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{
+		worlds: make([]*fileWorld, 0, len(paths)),
+		layers: make(map[int]fileLayer),
+	}
+
+	for _, p := range paths {
+		w, err := world.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if w.Store == nil {
+			return nil, fmt.Errorf("%v has no store, write it with cddamapgen --filestore", p)
+		}
+		fs.worlds = append(fs.worlds, &fileWorld{world: w, store: *w.Store})
+		log.WithField("world", w.Name).Info("opened file store")
+	}
+
+	sort.Slice(fs.worlds, func(i, j int) bool {
+		return fs.worlds[i].world.Name < fs.worlds[j].world.Name
+	})
+
+	layerID := 1
+	for i, fw := range fs.worlds {
+		fw.id = i + 1
+		fw.indexCharacters()
+
+		for _, l := range fw.store.Layers {
+			fs.layers[layerID] = fileLayer{world: fw, layer: l}
+			layerID++
+		}
+	}
+
+	return fs, nil
+}
+
+// indexCharacters numbers the characters of the world, including those only
+// known from their seen layers, in name order.
+func (fw *fileWorld) indexCharacters() {
+	w := fw.world
+
+	ids := make([]string, 0, len(w.Characters))
+	for id := range w.Characters {
+		ids = append(ids, id)
+	}
+	for id := range w.SeenLayers {
+		if _, ok := w.Characters[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return fw.characterName(ids[i]) < fw.characterName(ids[j])
+	})
+
+	fw.characters = make([]Character, 0, len(ids))
+	fw.characterIDs = make(map[string]int)
+	for i, id := range ids {
+		c := Character{
+			ID:   i + 1,
+			Name: fw.characterName(id),
+		}
+		if wc, ok := w.Characters[id]; ok && wc.Located {
+			c.Located = true
+			c.OvermapX = null.IntFrom(int64(wc.OvermapX))
+			c.OvermapY = null.IntFrom(int64(wc.OvermapY))
+			c.Z = null.IntFrom(int64(wc.Z))
+			c.Turn = null.IntFrom(int64(wc.Turn))
+			c.X = null.FloatFrom((float64(wc.X) + 0.5) * fw.store.CellWidth)
+			c.Y = null.FloatFrom((float64(wc.Y) + 0.5) * fw.store.CellHeight)
+		}
+		fw.characters = append(fw.characters, c)
+		fw.characterIDs[id] = c.ID
+	}
+}
+
+func (fw *fileWorld) characterName(id string) string {
+	if c, ok := fw.world.Characters[id]; ok && c.Name != "" {
+		return c.Name
+	}
+	return id
+}
+
+func (fs *FileStore) world(worldID int) (*fileWorld, error) {
+	if worldID < 1 || worldID > len(fs.worlds) {
+		return nil, fmt.Errorf("no world %v", worldID)
+	}
+	return fs.worlds[worldID-1], nil
+}
+
+func (fs *FileStore) layer(layerID int, snapshotID null.Int) (fileLayer, error) {
+	if snapshotID.Valid {
+		return fileLayer{}, errNoDatabase
+	}
+
+	l, ok := fs.layers[layerID]
+	if !ok {
+		return fileLayer{}, fmt.Errorf("no layer %v", layerID)
+	}
+	return l, nil
+}
+
+func (fs *FileStore) GetWorlds() ([]World, error) {
+	worlds := make([]World, 0, len(fs.worlds))
+	for _, fw := range fs.worlds {
+		worlds = append(worlds, World{ID: fw.id, Name: fw.world.Name})
+	}
+	return worlds, nil
+}
+
+func (fs *FileStore) GetWorldInfo(worldID int) (WorldInfo, error) {
+	fw, err := fs.world(worldID)
+	if err != nil {
+		return WorldInfo{}, err
+	}
+
+	worldInfo := WorldInfo{
+		ID:         fw.id,
+		Name:       fw.world.Name,
+		MaxZ:       fw.store.MaxZ,
+		Z:          make(map[int]*ZLevel),
+		Characters: fw.characters,
+	}
+
+	for layerID, fl := range fs.layers {
+		if fl.world != fw {
+			continue
+		}
+
+		z, ok := worldInfo.Z[fl.layer.Z]
+		if !ok {
+			z = newZLevel()
+			worldInfo.Z[fl.layer.Z] = z
+		}
+
+		var characterName, layerName null.String
+		if fl.layer.Character != "" {
+			characterName = null.StringFrom(fw.characterName(fl.layer.Character))
+		}
+		if fl.layer.Name != "" {
+			layerName = null.StringFrom(fl.layer.Name)
+		}
+		z.addLayer(fl.layer.Type, characterName, layerName, layerID)
+	}
+
+	return worldInfo, nil
+}
+
+type cellFeatureCollection struct {
+	Type     string        `json:"type"`
+	Features []cellFeature `json:"features"`
+}
+
+type cellFeature struct {
+	Type       string         `json:"type"`
+	Geometry   cellGeometry   `json:"geometry"`
+	Properties cellProperties `json:"properties"`
+}
+
+type cellGeometry struct {
+	Type        string           `json:"type"`
+	Coordinates [][][][2]float64 `json:"coordinates"`
+}
+
+type cellProperties struct {
+	ID       string      `json:"id"`
+	Name     string      `json:"name"`
+	Symbol   string      `json:"symbol"`
+	ColorFG  string      `json:"colorFg"`
+	ColorBG  string      `json:"colorBg"`
+	Special  null.String `json:"special"`
+	OvermapX int         `json:"overmapX"`
+	OvermapY int         `json:"overmapY"`
+	Z        int         `json:"z"`
+	ChunkX   int         `json:"chunkX"`
+	ChunkY   int         `json:"chunkY"`
+	City     *cellCity   `json:"city"`
+}
+
+type cellCity struct {
+	Name     string  `json:"name"`
+	Size     int     `json:"size"`
+	OvermapX int     `json:"overmapX"`
+	OvermapY int     `json:"overmapY"`
+	Distance float64 `json:"distance"`
+	InCity   bool    `json:"inCity"`
+}
+
+// GetCellJson returns the terrain under a point as GeoJSON, in the same shape
+// DB.GetCellJson does, with the area of equal terrain around it taken from
+// the layer's grid index.
+func (fs *FileStore) GetCellJson(layerID int, x, y float64, snapshotID, characterID null.Int) ([]byte, error) {
+	fl, err := fs.layer(layerID, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := cellFeatureCollection{Type: "FeatureCollection"}
+
+	fw := fl.world
+	idx, ok := fw.store.Terrain[fl.layer.Z]
+	if fl.layer.Type != "overmap" || !ok {
+		return json.Marshal(fc)
+	}
+
+	g := fw.grid()
+	o := g.Square(coords.Pixel{X: x, Y: y}, coords.Z(fl.layer.Z)).OvermapTerrain()
+	c := g.Cell(o)
+
+	region := idx.Region(c.X, c.Y)
+	if region < 0 {
+		return json.Marshal(fc)
+	}
+
+	if characterID.Valid && !fw.seen(int(characterID.Int64), fl.layer.Z, c.X, c.Y) {
+		return json.Marshal(fc)
+	}
+
+	tc := fw.world.TerrainCellLookup[fw.world.TerrainLayers[fl.layer.Z].TerrainRows[c.Y].TerrainCellKeys[c.X]]
+	chunk := o.Chunk()
+
+	p := cellProperties{
+		ID:       tc.ID,
+		Name:     tc.Name,
+		Symbol:   tc.Symbol,
+		ColorFG:  hexColor(tc.ColorFG),
+		ColorBG:  hexColor(tc.ColorBG),
+		Special:  null.NewString(tc.Special, tc.Special != ""),
+		OvermapX: o.X,
+		OvermapY: o.Y,
+		Z:        o.Z,
+		ChunkX:   chunk.X,
+		ChunkY:   chunk.Y,
+		City:     fw.nearestCity(o),
+	}
+
+	fc.Features = append(fc.Features, cellFeature{
+		Type:       "Feature",
+		Geometry:   cellGeometry{Type: "MultiPolygon", Coordinates: fw.outline(idx.Outlines[region])},
+		Properties: p,
+	})
+	return json.Marshal(fc)
+}
+
+// outline places the corners of a region's outline in pixels.
+func (fw *fileWorld) outline(polygons [][][][2]int) [][][][2]float64 {
+	o := make([][][][2]float64, len(polygons))
+	for pi, p := range polygons {
+		o[pi] = make([][][2]float64, len(p))
+		for ri, ring := range p {
+			o[pi][ri] = make([][2]float64, len(ring))
+			for vi, v := range ring {
+				o[pi][ri][vi] = [2]float64{float64(v[0]) * fw.store.CellWidth, float64(v[1]) * fw.store.CellHeight}
+			}
+		}
+	}
+	return o
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// seen reports whether a character has seen a cell of a layer.
+func (fw *fileWorld) seen(characterID, layer, x, y int) bool {
+	for id, layers := range fw.world.SeenLayers {
+		if fw.characterIDs[id] != characterID {
+			continue
+		}
+		rows := layers[layer].SeenRows
+		if y < 0 || y >= len(rows) || x < 0 || x >= len(rows[y].SeenCellKeys) {
+			return false
+		}
+		return rows[y].SeenCellKeys[x]
+	}
+	return false
+}
+
+func (fw *fileWorld) nearestCity(o coords.OvermapTerrain) *cellCity {
+	var nearest *cellCity
+	for _, c := range fw.world.CityLayer.Cities {
+		cx := fw.world.OriginX + c.X
+		cy := fw.world.OriginY + c.Y
+		dx := cx - o.X
+		dy := cy - o.Y
+		d := math.Sqrt(float64(dx*dx + dy*dy))
+		if nearest != nil && d >= nearest.Distance {
+			continue
+		}
+
+		nearest = &cellCity{
+			Name:     c.Name,
+			Size:     c.Size,
+			OvermapX: cx,
+			OvermapY: cy,
+			Distance: d,
+			InCity:   math.Max(math.Abs(float64(dx)), math.Abs(float64(dy))) <= float64(c.Size),
+		}
+	}
+	return nearest
+}
+
+// grid returns the grid the world was rendered in.
+func (fw *fileWorld) grid() coords.Grid {
+	return coords.Grid{OriginX: fw.world.OriginX, OriginY: fw.world.OriginY, CellWidth: fw.store.CellWidth, CellHeight: fw.store.CellHeight}
+}
+
+// GetGrid returns the grid of the world's latest build, the only one a file
+// store knows.
+func (fs *FileStore) GetGrid(worldID int, snapshotID null.Int) (coords.Grid, error) {
+	if snapshotID.Valid {
+		return coords.Grid{}, errNoDatabase
+	}
+
+	fw, err := fs.world(worldID)
+	if err != nil {
+		return coords.Grid{}, err
+	}
+	return fw.grid(), nil
+}
+
+func (fs *FileStore) GetTileRoot(layerID int, snapshotID null.Int) (string, error) {
+	fl, err := fs.layer(layerID, snapshotID)
+	if err != nil {
+		return "", err
+	}
+	return fl.layer.TileRoot, nil
+}
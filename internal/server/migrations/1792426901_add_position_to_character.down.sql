@@ -0,0 +1,7 @@
+alter table character drop constraint character_world_namehash_key;
+alter table character drop column the_geom;
+alter table character drop column turn;
+alter table character drop column z;
+alter table character drop column overmap_y;
+alter table character drop column overmap_x;
+alter table character drop column located;
\ No newline at end of file
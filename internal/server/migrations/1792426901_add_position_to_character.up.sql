@@ -0,0 +1,7 @@
+alter table character add column located boolean not null default false;
+alter table character add column overmap_x int null;
+alter table character add column overmap_y int null;
+alter table character add column z int null;
+alter table character add column turn int null;
+alter table character add column the_geom geometry(POINT) null;
+alter table character add constraint character_world_namehash_key unique (world_id, namehash);
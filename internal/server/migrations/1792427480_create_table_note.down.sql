@@ -0,0 +1 @@
+drop table note;
\ No newline at end of file
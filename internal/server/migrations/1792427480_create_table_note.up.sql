@@ -0,0 +1,21 @@
+create table note
+(
+    note_id serial not null,
+    character_id int not null,
+    z int not null,
+    overmap_x int not null,
+    overmap_y int not null,
+    symbol character varying not null,
+    color character varying not null,
+    text character varying not null,
+    dangerous boolean not null default false,
+    danger_radius int not null default 0,
+    the_geom geometry(POINT) not null,
+    danger_geom geometry(POLYGON) null,
+    created_at timestamp with time zone not null default now(),
+    constraint note_pkey primary key (note_id)
+);
+
+alter table note add constraint fk_note_character foreign key(character_id) references character(character_id);
+create index note_character_id_idx on note (character_id);
+create index note_gix ON note using gist (the_geom);
@@ -0,0 +1,23 @@
+drop view v_cell;
+
+create view v_cell as
+select 
+	w.world_id, l.layer_id, c.cell_id, l.z, c.id, c.name, c.the_geom
+from 
+	cell c 
+	inner join layer l 
+		on c.layer_id = l.layer_id
+	inner join world w
+		on w.world_id = l.world_id;
+
+-- only the latest snapshot of each layer survives going back
+delete from cell c 
+where 
+	c.snapshot_id <> (select max(ls.snapshot_id) from layer_snapshot ls where ls.layer_id = c.layer_id);
+
+drop index cell_layer_id_snapshot_id_idx;
+alter table cell drop constraint fk_cell_snapshot;
+alter table cell drop column snapshot_id;
+
+drop table layer_snapshot;
+drop table snapshot;
@@ -0,0 +1,63 @@
+create table snapshot
+(
+    snapshot_id serial not null,
+    world_id int not null,
+    turn int null,
+    created_at timestamp with time zone not null default now(),
+    constraint snapshot_pkey primary key (snapshot_id)
+);
+
+alter table snapshot add constraint fk_snapshot_world foreign key(world_id) references world(world_id);
+create index snapshot_world_id_idx on snapshot (world_id);
+
+create table layer_snapshot
+(
+    layer_id int not null,
+    snapshot_id int not null,
+    tile_root character varying not null,
+    constraint layer_snapshot_pkey primary key (layer_id, snapshot_id)
+);
+
+alter table layer_snapshot add constraint fk_layer_snapshot_layer foreign key(layer_id) references layer(layer_id);
+alter table layer_snapshot add constraint fk_layer_snapshot_snapshot foreign key(snapshot_id) references snapshot(snapshot_id);
+
+-- everything imported before snapshots becomes the first snapshot of its world
+insert into snapshot (world_id, created_at) select world_id, created_at from world;
+
+insert into layer_snapshot (layer_id, snapshot_id, tile_root)
+select 
+	t.layer_id, s.snapshot_id, t.tile_root
+from 
+	v_tile t
+	inner join layer l
+		on l.layer_id = t.layer_id
+	inner join snapshot s
+		on s.world_id = l.world_id
+where
+	t.tile_root is not null;
+
+alter table cell add column snapshot_id int null;
+
+update cell c set snapshot_id = s.snapshot_id
+from 
+	layer l
+	inner join snapshot s
+		on s.world_id = l.world_id
+where
+	c.layer_id = l.layer_id;
+
+alter table cell alter column snapshot_id set not null;
+alter table cell add constraint fk_cell_snapshot foreign key(snapshot_id) references snapshot(snapshot_id);
+create index cell_layer_id_snapshot_id_idx on cell (layer_id, snapshot_id);
+
+drop view v_cell;
+
+create view v_cell as
+select 
+	w.world_id, l.layer_id, c.snapshot_id, c.cell_id, l.z, c.id, c.name, c.the_geom
+from 
+	cell c 
+	inner join layer l 
+		on c.layer_id = l.layer_id
+	inner join world w
+		on w.world_id = l.world_id;
@@ -0,0 +1,10 @@
+alter table layer_snapshot drop column snapshot_tiles;
+
+-- only the cities of the latest snapshot with cities survive going back
+delete from city c where c.snapshot_id <> city_snapshot(c.world_id, null);
+
+drop function city_snapshot(int, int);
+
+drop index city_world_id_snapshot_id_idx;
+alter table city drop constraint fk_city_snapshot;
+alter table city drop column snapshot_id;
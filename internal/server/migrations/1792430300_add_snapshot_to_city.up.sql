@@ -0,0 +1,22 @@
+-- cities were replaced on every import, so the ones stored belong to the
+-- latest snapshot of their world
+alter table city add column snapshot_id int null;
+
+update city c set snapshot_id = (select max(s.snapshot_id) from snapshot s where s.world_id = c.world_id);
+
+delete from city where snapshot_id is null;
+
+alter table city alter column snapshot_id set not null;
+alter table city add constraint fk_city_snapshot foreign key(snapshot_id) references snapshot(snapshot_id);
+create index city_world_id_snapshot_id_idx on city (world_id, snapshot_id);
+
+-- imports without --cities keep the cities of the snapshot before them
+create function city_snapshot(int, int) returns int as $$
+	select max(snapshot_id) from city where world_id = $1 and ($2 is null or snapshot_id <= $2)
+$$ language sql stable;
+
+-- whether the tiles were written to a folder of the snapshot's own, rather
+-- than over the tiles of earlier snapshots
+alter table layer_snapshot add column snapshot_tiles boolean not null default false;
+
+update layer_snapshot set snapshot_tiles = tile_root like '%/snapshots/' || snapshot_id || '/%';
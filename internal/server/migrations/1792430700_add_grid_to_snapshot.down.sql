@@ -0,0 +1,4 @@
+alter table snapshot drop column cell_height;
+alter table snapshot drop column cell_width;
+alter table snapshot drop column origin_y;
+alter table snapshot drop column origin_x;
\ No newline at end of file
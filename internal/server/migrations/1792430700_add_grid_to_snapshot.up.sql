@@ -0,0 +1,4 @@
+alter table snapshot add column origin_x int null;
+alter table snapshot add column origin_y int null;
+alter table snapshot add column cell_width double precision null;
+alter table snapshot add column cell_height double precision null;
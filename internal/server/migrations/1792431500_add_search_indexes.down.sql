@@ -0,0 +1,3 @@
+drop index city_name_trgm_idx;
+drop index cell_id_trgm_idx;
+drop index cell_name_trgm_idx;
\ No newline at end of file
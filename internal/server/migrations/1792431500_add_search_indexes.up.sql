@@ -0,0 +1,5 @@
+create extension if not exists pg_trgm;
+
+create index cell_name_trgm_idx on cell using gin (name gin_trgm_ops);
+create index cell_id_trgm_idx on cell using gin (id gin_trgm_ops);
+create index city_name_trgm_idx on city using gin (name gin_trgm_ops);
@@ -0,0 +1,17 @@
+drop table building;
+
+drop view v_cell;
+
+-- merged rows can't be split back into cells, so they keep their first polygon
+alter table cell drop column cells;
+alter table cell alter column the_geom type geometry(POLYGON) using st_geometryn(the_geom, 1);
+
+create view v_cell as
+select 
+	w.world_id, l.layer_id, c.snapshot_id, c.cell_id, l.z, c.id, c.name, c.the_geom
+from 
+	cell c 
+	inner join layer l 
+		on c.layer_id = l.layer_id
+	inner join world w
+		on w.world_id = l.world_id;
@@ -0,0 +1,31 @@
+drop view v_cell;
+
+alter table cell alter column the_geom type geometry(MULTIPOLYGON) using st_multi(the_geom);
+alter table cell add column cells int not null default 1;
+
+create view v_cell as
+select 
+	w.world_id, l.layer_id, c.snapshot_id, c.cell_id, l.z, c.id, c.name, c.cells, c.the_geom
+from 
+	cell c 
+	inner join layer l 
+		on c.layer_id = l.layer_id
+	inner join world w
+		on w.world_id = l.world_id;
+
+create table building
+(
+    building_id serial not null,
+    layer_id int not null,
+    snapshot_id int not null,
+    special character varying not null,
+    cells int not null,
+    the_geom geometry(MULTIPOLYGON) not null,
+    created_at timestamp with time zone not null default now(),
+    constraint building_pkey primary key (building_id)
+);
+
+alter table building add constraint fk_building_layer foreign key(layer_id) references layer(layer_id);
+alter table building add constraint fk_building_snapshot foreign key(snapshot_id) references snapshot(snapshot_id);
+create index building_layer_id_snapshot_id_idx on building (layer_id, snapshot_id);
+create index building_gix ON building using gist (the_geom);
@@ -0,0 +1,10 @@
+drop function snapshot_pixel_envelope(double precision, double precision, double precision, double precision, int);
+drop function snapshot_pixel_point(double precision, double precision, int);
+drop function snapshot_to_pixel(geometry, int);
+drop function latest_snapshot(int);
+
+alter table snapshot drop column offset_y;
+alter table snapshot drop column offset_x;
+alter table snapshot drop column scale_y;
+alter table snapshot drop column scale_x;
+alter table snapshot drop column srid;
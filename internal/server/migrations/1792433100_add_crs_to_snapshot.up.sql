@@ -0,0 +1,44 @@
+alter table snapshot add column srid int not null default 0;
+alter table snapshot add column scale_x double precision not null default 1;
+alter table snapshot add column scale_y double precision not null default 1;
+alter table snapshot add column offset_x double precision not null default 0;
+alter table snapshot add column offset_y double precision not null default 0;
+
+-- geometry is stored in the crs of its snapshot, while the api speaks the
+-- pixel coordinates of the tiles; these convert between the two
+create function latest_snapshot(int) returns int as $$
+	select max(snapshot_id) from snapshot where world_id = $1
+$$ language sql stable;
+
+create function snapshot_to_pixel(geometry, int) returns geometry as $$
+	select 
+		st_setsrid(st_affine($1, 1 / s.scale_x, 0, 0, 1 / s.scale_y, -s.offset_x / s.scale_x, -s.offset_y / s.scale_y), 0)
+	from 
+		snapshot s 
+	where 
+		s.snapshot_id = $2
+$$ language sql stable;
+
+create function snapshot_pixel_point(double precision, double precision, int) returns geometry as $$
+	select 
+		st_setsrid(st_makepoint($1 * s.scale_x + s.offset_x, $2 * s.scale_y + s.offset_y), s.srid)
+	from 
+		snapshot s 
+	where 
+		s.snapshot_id = $3
+$$ language sql stable;
+
+create function snapshot_pixel_envelope(double precision, double precision, double precision, double precision, int) returns geometry as $$
+	select 
+		st_makeenvelope(
+			least($1 * s.scale_x, $3 * s.scale_x) + s.offset_x,
+			least($2 * s.scale_y, $4 * s.scale_y) + s.offset_y,
+			greatest($1 * s.scale_x, $3 * s.scale_x) + s.offset_x,
+			greatest($2 * s.scale_y, $4 * s.scale_y) + s.offset_y,
+			s.srid
+		)
+	from 
+		snapshot s 
+	where 
+		s.snapshot_id = $5
+$$ language sql stable;
@@ -0,0 +1,18 @@
+alter table city drop column overmap_y;
+alter table city drop column overmap_x;
+
+drop view v_cell;
+
+alter table cell drop column color_bg;
+alter table cell drop column color_fg;
+alter table cell drop column symbol;
+
+create view v_cell as
+select 
+	w.world_id, l.layer_id, c.snapshot_id, c.cell_id, l.z, c.id, c.name, c.cells, c.the_geom
+from 
+	cell c 
+	inner join layer l 
+		on c.layer_id = l.layer_id
+	inner join world w
+		on w.world_id = l.world_id;
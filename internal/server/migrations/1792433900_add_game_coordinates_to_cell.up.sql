@@ -0,0 +1,31 @@
+drop view v_cell;
+
+-- a cell row is a merged area of one terrain, so the terrain's look is stored
+-- with it while the game coordinates of a single cell are worked out from the
+-- snapshot's grid when it's looked up
+alter table cell add column symbol character varying null;
+alter table cell add column color_fg character varying null;
+alter table cell add column color_bg character varying null;
+
+create view v_cell as
+select 
+	w.world_id, l.layer_id, c.snapshot_id, c.cell_id, l.z, c.id, c.name, c.symbol, c.color_fg, c.color_bg, c.cells, c.the_geom
+from 
+	cell c 
+	inner join layer l 
+		on c.layer_id = l.layer_id
+	inner join world w
+		on w.world_id = l.world_id;
+
+alter table city add column overmap_x int null;
+alter table city add column overmap_y int null;
+
+-- cities are kept per snapshot, so each is placed on the grid of its own
+update city c set
+	overmap_x = s.origin_x + floor(st_x(snapshot_to_pixel(c.the_geom, s.snapshot_id)) / s.cell_width)::int,
+	overmap_y = s.origin_y + floor(st_y(snapshot_to_pixel(c.the_geom, s.snapshot_id)) / s.cell_height)::int
+from 
+	snapshot s
+where 
+	s.snapshot_id = c.snapshot_id
+	and s.origin_x is not null;
@@ -0,0 +1,2 @@
+drop index layer_world_id_z_name_type_idx;
+alter table layer drop constraint layer_world_id_z_character_id_type_key;
@@ -0,0 +1,34 @@
+-- imports that ran side by side could create the same layer twice; fold
+-- duplicates into the oldest one before they're ruled out
+create temp table layer_duplicate as
+select
+	layer_id,
+	keep_id
+from
+	(
+		select
+			layer_id,
+			min(layer_id) over (partition by world_id, z, character_id, coalesce(name, ''), type) as keep_id
+		from
+			layer
+	) l
+where
+	layer_id <> keep_id;
+
+delete from cell c using layer_duplicate d, layer_snapshot k where c.layer_id = d.layer_id and k.layer_id = d.keep_id and k.snapshot_id = c.snapshot_id;
+delete from building b using layer_duplicate d, layer_snapshot k where b.layer_id = d.layer_id and k.layer_id = d.keep_id and k.snapshot_id = b.snapshot_id;
+delete from layer_snapshot ls using layer_duplicate d, layer_snapshot k where ls.layer_id = d.layer_id and k.layer_id = d.keep_id and k.snapshot_id = ls.snapshot_id;
+
+update cell c set layer_id = d.keep_id from layer_duplicate d where c.layer_id = d.layer_id;
+update building b set layer_id = d.keep_id from layer_duplicate d where b.layer_id = d.layer_id;
+update layer_snapshot ls set layer_id = d.keep_id from layer_duplicate d where ls.layer_id = d.layer_id;
+
+delete from layer l using layer_duplicate d where l.layer_id = d.layer_id;
+
+drop table layer_duplicate;
+
+alter table layer add constraint layer_world_id_z_character_id_type_key unique (world_id, z, character_id, type);
+
+-- layers without a character are told apart by name, and a unique constraint
+-- doesn't hold for nulls
+create unique index layer_world_id_z_name_type_idx on layer (world_id, z, (coalesce(name, '')), type) where character_id is null;
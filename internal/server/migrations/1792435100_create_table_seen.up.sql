@@ -0,0 +1,14 @@
+create table seen
+(
+    seen_id serial not null,
+    character_id int not null,
+    z int not null,
+    the_geom geometry(MULTIPOLYGON) not null,
+    created_at timestamp with time zone not null default now(),
+    constraint seen_pkey primary key (seen_id)
+);
+
+alter table seen add constraint fk_seen_character foreign key(character_id) references character(character_id);
+-- a character's seen area on a layer is one multipolygon
+alter table seen add constraint seen_character_id_z_key unique (character_id, z);
+create index seen_gix ON seen using gist (the_geom);
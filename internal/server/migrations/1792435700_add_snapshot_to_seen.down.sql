@@ -0,0 +1,10 @@
+-- only the latest seen area of each character and layer survives going back
+delete from seen se where se.snapshot_id <> seen_snapshot(se.character_id, se.z, null);
+
+drop function snapshot_translate(geometry, int, int);
+drop function seen_snapshot(int, int, int);
+
+alter table seen drop constraint seen_character_id_z_snapshot_id_key;
+alter table seen add constraint seen_character_id_z_key unique (character_id, z);
+alter table seen drop constraint fk_seen_snapshot;
+alter table seen drop column snapshot_id;
@@ -0,0 +1,44 @@
+-- seen areas were replaced on every import, so the ones stored belong to the
+-- latest snapshot of their character's world
+alter table seen add column snapshot_id int null;
+
+update seen se set snapshot_id = latest_snapshot(c.world_id)
+from
+	character c
+where
+	c.character_id = se.character_id;
+
+delete from seen where snapshot_id is null;
+
+alter table seen alter column snapshot_id set not null;
+alter table seen add constraint fk_seen_snapshot foreign key(snapshot_id) references snapshot(snapshot_id);
+alter table seen drop constraint seen_character_id_z_key;
+alter table seen add constraint seen_character_id_z_snapshot_id_key unique (character_id, z, snapshot_id);
+
+-- imports without --seen keep the seen areas of the snapshot before them
+create function seen_snapshot(int, int, int) returns int as $$
+	select max(snapshot_id) from seen where character_id = $1 and z = $2 and ($3 is null or snapshot_id <= $3)
+$$ language sql stable;
+
+-- moves geometry stored in the crs of one snapshot into the crs of another,
+-- lining the two up by their grids as the world grows
+create function snapshot_translate(geometry, int, int) returns geometry as $$
+	select
+		case
+			when $2 = $3 then $1
+			else st_setsrid(st_affine(
+				st_translate(
+					snapshot_to_pixel($1, f.snapshot_id),
+					(coalesce(f.origin_x, 0) - coalesce(t.origin_x, 0)) * coalesce(f.cell_width, 0),
+					(coalesce(f.origin_y, 0) - coalesce(t.origin_y, 0)) * coalesce(f.cell_height, 0)
+				),
+				t.scale_x, 0, 0, t.scale_y, t.offset_x, t.offset_y
+			), t.srid)
+		end
+	from
+		snapshot f,
+		snapshot t
+	where
+		f.snapshot_id = $2
+		and t.snapshot_id = $3
+$$ language sql stable;
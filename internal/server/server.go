@@ -2,13 +2,17 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/guregu/null"
+	"github.com/ralreegorganon/cddamap/internal/gen/coords"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -18,6 +22,14 @@ func CreateRouter(server *HTTPServer) (*mux.Router, error) {
 		"GET": {
 			"/api/worlds":                                                                                     server.GetWorlds,
 			"/api/worlds/{worldID:[0-9]+}":                                                                    server.GetWorldLayerInfo,
+			"/api/worlds/{worldID:[0-9]+}/characters":                                                         server.GetCharacters,
+			"/api/worlds/{worldID:[0-9]+}/snapshots":                                                          server.GetSnapshots,
+			"/api/worlds/{worldID:[0-9]+}/diff":                                                               server.GetDiff,
+			"/api/worlds/{worldID:[0-9]+}/search":                                                             server.Search,
+			"/api/worlds/{worldID:[0-9]+}/coordinates/{x}/{y}":                                                server.GetCoordinates,
+			"/api/worlds/{worldID:[0-9]+}/characters/{characterID:[0-9]+}/notes":                              server.GetNotes,
+			"/api/worlds/{worldID:[0-9]+}/characters/{characterID:[0-9]+}/seen":                               server.GetVisibility,
+			"/api/worlds/{worldID:[0-9]+}/layers/{layerID:[0-9]+}/cells":                                      server.GetCellsInBBox,
 			"/api/worlds/{worldID:[0-9]+}/layers/{layerID:[0-9]+}/cells/{x}/{y}":                              server.GetCells,
 			"/api/worlds/{worldID:[0-9]+}/layers/{layerID:[0-9]+}/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.png": server.GetTile,
 		},
@@ -64,12 +76,17 @@ func writeCorsHeaders(w http.ResponseWriter, r *http.Request) {
 type HttpApiFunc func(w http.ResponseWriter, r *http.Request, vars map[string]string) error
 
 type HTTPServer struct {
+	Store Store
+	// DB is set when the store is a database, for the endpoints only PostGIS
+	// can answer.
 	DB       *DB
 	tileRoot string
 }
 
-func NewHTTPServer(db *DB, tileRoot string) *HTTPServer {
+func NewHTTPServer(store Store, tileRoot string) *HTTPServer {
+	db, _ := store.(*DB)
 	s := &HTTPServer{
+		Store:    store,
 		DB:       db,
 		tileRoot: tileRoot,
 	}
@@ -93,6 +110,12 @@ func writeJSONDirect(w http.ResponseWriter, code int, thing []byte) {
 
 func httpError(w http.ResponseWriter, err error) {
 	statusCode := http.StatusInternalServerError
+	switch err {
+	case errNoDatabase:
+		statusCode = http.StatusNotImplemented
+	case errNoSnapshotTiles:
+		statusCode = http.StatusNotFound
+	}
 
 	if err != nil {
 		log.WithField("err", err).Error("http error")
@@ -106,7 +129,7 @@ func options(w http.ResponseWriter, r *http.Request, vars map[string]string) err
 }
 
 func (s *HTTPServer) GetWorlds(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
-	worlds, err := s.DB.GetWorlds()
+	worlds, err := s.Store.GetWorlds()
 
 	if err != nil {
 		return err
@@ -123,7 +146,7 @@ func (s *HTTPServer) GetWorldLayerInfo(w http.ResponseWriter, r *http.Request, v
 		return err
 	}
 
-	worldInfo, err := s.DB.GetWorldInfo(worldID)
+	worldInfo, err := s.Store.GetWorldInfo(worldID)
 
 	if err != nil {
 		return err
@@ -134,6 +157,328 @@ func (s *HTTPServer) GetWorldLayerInfo(w http.ResponseWriter, r *http.Request, v
 	return nil
 }
 
+func (s *HTTPServer) GetCharacters(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if s.DB == nil {
+		return errNoDatabase
+	}
+
+	worldID, err := strconv.Atoi(vars["worldID"])
+	if err != nil {
+		return err
+	}
+
+	characters, err := s.DB.GetCharacters(worldID)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, characters)
+
+	return nil
+}
+
+func (s *HTTPServer) GetNotes(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if s.DB == nil {
+		return errNoDatabase
+	}
+
+	worldID, err := strconv.Atoi(vars["worldID"])
+	if err != nil {
+		return err
+	}
+
+	characterID, err := strconv.Atoi(vars["characterID"])
+	if err != nil {
+		return err
+	}
+
+	var z null.Int
+	if q := r.URL.Query().Get("z"); q != "" {
+		zi, err := strconv.Atoi(q)
+		if err != nil {
+			return err
+		}
+		z = null.IntFrom(int64(zi))
+	}
+
+	json, err := s.DB.GetNotesJson(worldID, characterID, z)
+	if err != nil {
+		return err
+	}
+	writeJSONDirect(w, http.StatusOK, json)
+	return nil
+}
+
+func (s *HTTPServer) GetSnapshots(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if s.DB == nil {
+		return errNoDatabase
+	}
+
+	worldID, err := strconv.Atoi(vars["worldID"])
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := s.DB.GetSnapshots(worldID)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, snapshots)
+
+	return nil
+}
+
+func (s *HTTPServer) GetDiff(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if s.DB == nil {
+		return errNoDatabase
+	}
+
+	worldID, err := strconv.Atoi(vars["worldID"])
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+
+	from, err := strconv.Atoi(q.Get("from"))
+	if err != nil {
+		return err
+	}
+
+	to, err := strconv.Atoi(q.Get("to"))
+	if err != nil {
+		return err
+	}
+
+	var z null.Int
+	if zq := q.Get("z"); zq != "" {
+		zi, err := strconv.Atoi(zq)
+		if err != nil {
+			return err
+		}
+		z = null.IntFrom(int64(zi))
+	}
+
+	json, err := s.DB.GetDiffJson(worldID, from, to, z)
+	if err != nil {
+		return err
+	}
+	writeJSONDirect(w, http.StatusOK, json)
+	return nil
+}
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	defaultCellsLimit  = 500
+	maxCellsLimit      = 5000
+)
+
+func (s *HTTPServer) Search(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if s.DB == nil {
+		return errNoDatabase
+	}
+
+	worldID, err := strconv.Atoi(vars["worldID"])
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+
+	sq := SearchQuery{
+		Text:  q.Get("q"),
+		Limit: defaultSearchLimit,
+	}
+	if sq.Text == "" {
+		return fmt.Errorf("missing search text")
+	}
+
+	if zq := q.Get("z"); zq != "" {
+		zi, err := strconv.Atoi(zq)
+		if err != nil {
+			return err
+		}
+		sq.Z = null.IntFrom(int64(zi))
+	}
+
+	if bq := q.Get("bbox"); bq != "" {
+		sq.BBox, err = parseBBox(bq)
+		if err != nil {
+			return err
+		}
+	}
+
+	sq.CharacterID, err = characterParam(r)
+	if err != nil {
+		return err
+	}
+
+	if lq := q.Get("limit"); lq != "" {
+		sq.Limit, err = strconv.Atoi(lq)
+		if err != nil {
+			return err
+		}
+	}
+	if sq.Limit < 1 || sq.Limit > maxSearchLimit {
+		return fmt.Errorf("limit must be between 1 and %v", maxSearchLimit)
+	}
+
+	if oq := q.Get("offset"); oq != "" {
+		sq.Offset, err = strconv.Atoi(oq)
+		if err != nil {
+			return err
+		}
+	}
+	if sq.Offset < 0 {
+		return fmt.Errorf("offset must not be negative")
+	}
+
+	json, err := s.DB.SearchJson(worldID, sq)
+	if err != nil {
+		return err
+	}
+	writeJSONDirect(w, http.StatusOK, json)
+	return nil
+}
+
+// parseBBox parses a minX,minY,maxX,maxY bounding box.
+func parseBBox(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bbox must be minX,minY,maxX,maxY")
+	}
+
+	bbox := make([]float64, 0, 4)
+	for _, p := range parts {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return nil, err
+		}
+		bbox = append(bbox, f)
+	}
+	return bbox, nil
+}
+
+// snapshotParam reads the optional ?snapshot= query parameter that asks for a
+// layer as of an earlier import.
+func snapshotParam(r *http.Request) (null.Int, error) {
+	q := r.URL.Query().Get("snapshot")
+	if q == "" {
+		return null.Int{}, nil
+	}
+
+	id, err := strconv.Atoi(q)
+	if err != nil {
+		return null.Int{}, err
+	}
+	return null.IntFrom(int64(id)), nil
+}
+
+// GetCoordinates converts a position on the map, in pixels, to the game's
+// coordinate systems. The z level defaults to the surface.
+func (s *HTTPServer) GetCoordinates(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	worldID, err := strconv.Atoi(vars["worldID"])
+	if err != nil {
+		return err
+	}
+
+	x, err := strconv.ParseFloat(vars["x"], 64)
+	if err != nil {
+		return err
+	}
+
+	y, err := strconv.ParseFloat(vars["y"], 64)
+	if err != nil {
+		return err
+	}
+
+	z := 0
+	if q := r.URL.Query().Get("z"); q != "" {
+		z, err = strconv.Atoi(q)
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshotID, err := snapshotParam(r)
+	if err != nil {
+		return err
+	}
+
+	g, err := s.Store.GetGrid(worldID, snapshotID)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, g.Position(coords.Pixel{X: x, Y: y}, z))
+}
+
+// characterParam reads the optional ?character= query parameter that limits
+// results to what a character has seen.
+func characterParam(r *http.Request) (null.Int, error) {
+	q := r.URL.Query().Get("character")
+	if q == "" {
+		return null.Int{}, nil
+	}
+
+	id, err := strconv.Atoi(q)
+	if err != nil {
+		return null.Int{}, err
+	}
+	return null.IntFrom(int64(id)), nil
+}
+
+// GetVisibility tells whether a character has seen a point, given as x and
+// y, or how much of a bounding box it has seen on game z-level z.
+func (s *HTTPServer) GetVisibility(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if s.DB == nil {
+		return errNoDatabase
+	}
+
+	worldID, err := strconv.Atoi(vars["worldID"])
+	if err != nil {
+		return err
+	}
+
+	characterID, err := strconv.Atoi(vars["characterID"])
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+
+	z, err := strconv.Atoi(q.Get("z"))
+	if err != nil {
+		return fmt.Errorf("z must be a game z-level: %v", err)
+	}
+
+	var bbox []float64
+	if bq := q.Get("bbox"); bq != "" {
+		bbox, err = parseBBox(bq)
+		if err != nil {
+			return err
+		}
+	} else {
+		x, err := strconv.ParseFloat(q.Get("x"), 64)
+		if err != nil {
+			return fmt.Errorf("missing bbox or x and y: %v", err)
+		}
+		y, err := strconv.ParseFloat(q.Get("y"), 64)
+		if err != nil {
+			return fmt.Errorf("missing bbox or x and y: %v", err)
+		}
+		bbox = []float64{x, y, x, y}
+	}
+
+	v, err := s.DB.GetVisibility(worldID, characterID, coords.Layer(z), bbox)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, v)
+}
+
 func (s *HTTPServer) GetCells(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	layerID, err := strconv.Atoi(vars["layerID"])
 	if err != nil {
@@ -150,7 +495,82 @@ func (s *HTTPServer) GetCells(w http.ResponseWriter, r *http.Request, vars map[s
 		return err
 	}
 
-	json, err := s.DB.GetCellJson(layerID, x, y)
+	snapshotID, err := snapshotParam(r)
+	if err != nil {
+		return err
+	}
+
+	characterID, err := characterParam(r)
+	if err != nil {
+		return err
+	}
+
+	json, err := s.Store.GetCellJson(layerID, x, y, snapshotID, characterID)
+	if err != nil {
+		return err
+	}
+	writeJSONDirect(w, http.StatusOK, json)
+	return nil
+}
+
+func (s *HTTPServer) GetCellsInBBox(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if s.DB == nil {
+		return errNoDatabase
+	}
+
+	layerID, err := strconv.Atoi(vars["layerID"])
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+
+	cq := CellQuery{
+		Limit: defaultCellsLimit,
+	}
+
+	bq := q.Get("bbox")
+	if bq == "" {
+		return fmt.Errorf("missing bbox")
+	}
+	cq.BBox, err = parseBBox(bq)
+	if err != nil {
+		return err
+	}
+
+	if zq := q.Get("zoom"); zq != "" {
+		zi, err := strconv.Atoi(zq)
+		if err != nil {
+			return err
+		}
+		cq.Zoom = null.IntFrom(int64(zi))
+	}
+
+	if id := q.Get("id"); id != "" {
+		cq.TerrainID = null.StringFrom(id)
+	}
+
+	if lq := q.Get("limit"); lq != "" {
+		cq.Limit, err = strconv.Atoi(lq)
+		if err != nil {
+			return err
+		}
+	}
+	if cq.Limit < 1 || cq.Limit > maxCellsLimit {
+		return fmt.Errorf("limit must be between 1 and %v", maxCellsLimit)
+	}
+
+	cq.SnapshotID, err = snapshotParam(r)
+	if err != nil {
+		return err
+	}
+
+	cq.CharacterID, err = characterParam(r)
+	if err != nil {
+		return err
+	}
+
+	json, err := s.DB.GetCellsInBBoxJson(layerID, cq)
 	if err != nil {
 		return err
 	}
@@ -179,7 +599,15 @@ func (s *HTTPServer) GetTile(w http.ResponseWriter, r *http.Request, vars map[st
 		return err
 	}
 
-	t, err := s.DB.GetTileRoot(layerID)
+	snapshotID, err := snapshotParam(r)
+	if err != nil {
+		return err
+	}
+
+	t, err := s.Store.GetTileRoot(layerID, snapshotID)
+	if err == errNoSnapshotTiles {
+		return err
+	}
 	if err != nil {
 		log.WithField("err", err).Error("db error")
 		w.WriteHeader(http.StatusNotFound)
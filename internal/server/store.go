@@ -0,0 +1,27 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/guregu/null"
+	"github.com/ralreegorganon/cddamap/internal/gen/coords"
+)
+
+// Store is where the server gets its worlds from: a PostGIS database through
+// DB, or a FileStore of worlds written by cddamapgen.
+type Store interface {
+	GetWorlds() ([]World, error)
+	GetWorldInfo(worldID int) (WorldInfo, error)
+	GetCellJson(layerID int, x, y float64, snapshotID, characterID null.Int) ([]byte, error)
+	GetGrid(worldID int, snapshotID null.Int) (coords.Grid, error)
+	GetTileRoot(layerID int, snapshotID null.Int) (string, error)
+}
+
+// errNoDatabase is returned by the endpoints only PostGIS can answer when the
+// server runs on a FileStore.
+var errNoDatabase = errors.New("not available without a database")
+
+// errNoSnapshotTiles is returned for the tiles of a snapshot that were
+// overwritten by a later import, as they weren't written to a folder of the
+// snapshot's own.
+var errNoSnapshotTiles = errors.New("the snapshot's tiles were overwritten by a later import, import with --snapshottiles to keep them")
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"time"
+
 	"github.com/guregu/null"
 )
 
@@ -17,17 +19,113 @@ type WorldLayerInfo struct {
 	Type          string      `json:"type" db:"type"`
 	WorldName     string      `json:"worldName" db:"world_name"`
 	CharacterName null.String `json:"characterName" db:"character_name"`
+	LayerName     null.String `json:"layerName" db:"layer_name"`
 }
 
 type ZLevel struct {
-	TerrainLayer   null.Int       `json:"layerId"`
-	SeenLayer      map[string]int `json:"seenLayers"`
-	SeenSolidLayer map[string]int `json:"seenSolidLayers"`
+	TerrainLayer               null.Int       `json:"layerId"`
+	SeenLayer                  map[string]int `json:"seenLayers"`
+	SeenSolidLayer             map[string]int `json:"seenSolidLayers"`
+	SeenUnionLayer             map[string]int `json:"seenUnionLayers"`
+	SeenUnionSolidLayer        map[string]int `json:"seenUnionSolidLayers"`
+	SeenIntersectionLayer      map[string]int `json:"seenIntersectionLayers"`
+	SeenIntersectionSolidLayer map[string]int `json:"seenIntersectionSolidLayers"`
+	MaskedLayer                map[string]int `json:"maskedLayers"`
+}
+
+func newZLevel() *ZLevel {
+	return &ZLevel{
+		SeenLayer:                  make(map[string]int),
+		SeenSolidLayer:             make(map[string]int),
+		SeenUnionLayer:             make(map[string]int),
+		SeenUnionSolidLayer:        make(map[string]int),
+		SeenIntersectionLayer:      make(map[string]int),
+		SeenIntersectionSolidLayer: make(map[string]int),
+		MaskedLayer:                make(map[string]int),
+	}
+}
+
+// addLayer files a layer under its type. Seen layers are keyed by the name
+// of their character, and combined ones by their own name.
+func (z *ZLevel) addLayer(layerType string, characterName, layerName null.String, layerID int) {
+	switch layerType {
+	case "overmap":
+		z.TerrainLayer = null.IntFrom(int64(layerID))
+	case "seen":
+		z.SeenLayer[characterName.String] = layerID
+	case "seen_solid":
+		z.SeenSolidLayer[characterName.String] = layerID
+	case "seen_union":
+		z.SeenUnionLayer[layerName.String] = layerID
+	case "seen_union_solid":
+		z.SeenUnionSolidLayer[layerName.String] = layerID
+	case "seen_intersection":
+		z.SeenIntersectionLayer[layerName.String] = layerID
+	case "seen_intersection_solid":
+		z.SeenIntersectionSolidLayer[layerName.String] = layerID
+	case "masked":
+		if characterName.Valid {
+			z.MaskedLayer[characterName.String] = layerID
+		} else {
+			z.MaskedLayer[layerName.String] = layerID
+		}
+	}
+}
+
+type Character struct {
+	ID       int        `json:"id" db:"character_id"`
+	Name     string     `json:"name" db:"name"`
+	Located  bool       `json:"located" db:"located"`
+	OvermapX null.Int   `json:"overmapX" db:"overmap_x"`
+	OvermapY null.Int   `json:"overmapY" db:"overmap_y"`
+	Z        null.Int   `json:"z" db:"z"`
+	Turn     null.Int   `json:"turn" db:"turn"`
+	X        null.Float `json:"x" db:"x"`
+	Y        null.Float `json:"y" db:"y"`
+}
+
+type Snapshot struct {
+	ID        int       `json:"id" db:"snapshot_id"`
+	Turn      null.Int  `json:"turn" db:"turn"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 }
 
 type WorldInfo struct {
-	ID   int             `json:"id"`
-	Name string          `json:"name"`
-	MaxZ int             `json:"maxz"`
-	Z    map[int]*ZLevel `json:"z"`
+	ID         int             `json:"id"`
+	Name       string          `json:"name"`
+	MaxZ       int             `json:"maxz"`
+	Z          map[int]*ZLevel `json:"z"`
+	Characters []Character     `json:"characters"`
+}
+
+// SearchQuery is a text search over a world's terrain and cities. Z is the
+// game's z-level, 0 at the surface. The bounding box is minX, minY, maxX, maxY
+// in map coordinates, and a character limits results to cells that character
+// has seen.
+type SearchQuery struct {
+	Text        string
+	Z           null.Int
+	BBox        []float64
+	CharacterID null.Int
+	Limit       int
+	Offset      int
+}
+
+// CellQuery asks for a layer's cells in a bounding box, merged per terrain
+// ID. Zoom is the tile zoom the geometry is simplified for, a terrain ID
+// limits the result to that terrain and a character to what it has seen.
+type CellQuery struct {
+	BBox        []float64
+	Zoom        null.Int
+	TerrainID   null.String
+	SnapshotID  null.Int
+	CharacterID null.Int
+	Limit       int
+}
+
+// Visibility says whether a character has seen a point or bounding box, and
+// how much of the bounding box it has seen.
+type Visibility struct {
+	Seen     bool    `json:"seen" db:"seen"`
+	Coverage float64 `json:"coverage" db:"coverage"`
 }